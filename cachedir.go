@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cache directories are tagged as described in the Cache Directory Tagging
+// Specification (https://bford.info/cachedir/)
+const (
+	cacheDirTagName      = "CACHEDIR.TAG"
+	cacheDirTagSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+)
+
+// Supported values of CleanOptions.CacheDirTag
+const (
+	CacheDirTagOnly   = "only"   // Only the contents of tagged directories are candidates
+	CacheDirTagPrefer = "prefer" // Tagged contents are deleted before anything else
+)
+
+// validateCacheDirTag checks a cachedir_tag setting
+func validateCacheDirTag(mode string) error {
+	switch mode {
+	case "", CacheDirTagOnly, CacheDirTagPrefer:
+		return nil
+	}
+	return fmt.Errorf("invalid cachedir_tag %q (expected only or prefer)", mode)
+}
+
+// isCacheDir reports whether dir holds a CACHEDIR.TAG file starting with the
+// signature line
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, cacheDirTagName))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(cacheDirTagSignature))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	return string(buf) == cacheDirTagSignature
+}
+
+// cacheDirTracker follows a directory walk in lexical order and tells whether
+// the current path lies within a tagged cache directory
+type cacheDirTracker struct {
+	current string // Innermost tagged directory being walked, if any
+}
+
+// inCache reports whether path is inside a tagged cache directory, checking
+// each directory for a tag as the walk enters it
+func (c *cacheDirTracker) inCache(path string, isDir bool) bool {
+	if c.current != "" && path != c.current && !strings.HasPrefix(path, c.current+string(filepath.Separator)) {
+		c.current = ""
+	}
+	if c.current == "" && isDir && isCacheDir(path) {
+		c.current = path
+	}
+	return c.current != ""
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeTree creates 100-byte files at the given relative paths, aged as given
+func makeTree(t *testing.T, root string, ages map[string]time.Duration) {
+	t.Helper()
+	for name, age := range ages {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeCacheDirTag(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, cacheDirTagName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanUp_CacheDirTagOnly(t *testing.T) {
+	home := t.TempDir()
+	makeTree(t, home, map[string]time.Duration{
+		"alice/.cache/thumbs/a.png":  1 * time.Hour,
+		"alice/documents/report.odt": 100 * time.Hour,
+		"bob/.cache/pip/wheel.whl":   2 * time.Hour,
+		"bob/fake/old.bin":           200 * time.Hour,
+	})
+	writeCacheDirTag(t, filepath.Join(home, "alice/.cache"), cacheDirTagSignature+"\n# This file is a cache directory tag.\n")
+	writeCacheDirTag(t, filepath.Join(home, "bob/.cache"), cacheDirTagSignature+"\n")
+	writeCacheDirTag(t, filepath.Join(home, "bob/fake"), "Signature: not a real tag\n")
+	if err := os.MkdirAll(filepath.Join(home, "bob/empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CleanOptions{CacheDirTag: CacheDirTagOnly}
+	target := allocated(t, filepath.Join(home, "alice/.cache/thumbs/a.png"), filepath.Join(home, "bob/.cache/pip/wheel.whl"))
+	if err := CleanUp([]string{home}, target, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+
+	for _, name := range []string{"alice/.cache/thumbs/a.png", "bob/.cache/pip/wheel.whl"} {
+		if _, err := os.Stat(filepath.Join(home, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+	for _, name := range []string{
+		"alice/documents/report.odt", "bob/fake/old.bin", "bob/empty",
+		"alice/.cache/" + cacheDirTagName, "bob/.cache/" + cacheDirTagName,
+	} {
+		if _, err := os.Stat(filepath.Join(home, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+}
+
+func TestCleanUp_CacheDirTagUnreadable(t *testing.T) {
+	home := t.TempDir()
+	makeTree(t, home, map[string]time.Duration{
+		"alice/.cache/a.bin": 2 * time.Hour,
+		"bob/.cache/b.bin":   1 * time.Hour,
+	})
+	writeCacheDirTag(t, filepath.Join(home, "alice/.cache"), cacheDirTagSignature+"\n")
+	writeCacheDirTag(t, filepath.Join(home, "bob/.cache"), cacheDirTagSignature+"\n")
+
+	private := filepath.Join(home, "bob")
+	if err := os.Chmod(private, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(private, 0755)
+	if _, err := os.ReadDir(private); err == nil {
+		t.Skip("Permissions are not enforced for this user")
+	}
+
+	// The private home is skipped instead of failing the whole search
+	opts := CleanOptions{CacheDirTag: CacheDirTagOnly}
+	target := allocated(t, filepath.Join(home, "alice/.cache/a.bin"))
+	if err := CleanUp([]string{home}, target, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "alice/.cache/a.bin")); !os.IsNotExist(err) {
+		t.Errorf("alice/.cache/a.bin should have been deleted")
+	}
+}
+
+func TestCleanUp_CacheDirTagPrefer(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root, map[string]time.Duration{
+		"build/cache/obj.o": 1 * time.Hour,
+		"data/old.csv":      100 * time.Hour,
+	})
+	writeCacheDirTag(t, filepath.Join(root, "build/cache"), cacheDirTagSignature)
+
+	// The cache goes first even though the data file is much older
+	opts := CleanOptions{CacheDirTag: CacheDirTagPrefer}
+	if err := CleanUp([]string{root}, 50, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "build/cache/obj.o")); !os.IsNotExist(err) {
+		t.Errorf("obj.o should have been deleted")
+	}
+	if _, err := os.Stat(filepath.Join(root, "data/old.csv")); err != nil {
+		t.Errorf("old.csv should still exist")
+	}
+}
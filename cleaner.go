@@ -1,44 +1,335 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"time"
 )
 
 // FileInfo holds minimal info needed for sorting and deletion
 type FileInfo struct {
-	Path string
-	Size int64
-	Age  int64 // Unix timestamp of modification time
+	Path     string
+	Size     int64
+	Age      int64  // Unix timestamp the file's age is measured from (see CleanOptions.AgeSource)
+	Written  int64  // Latest of the mtime and Age, which MinAge is measured against
+	Reclaim  int64  // Bytes actually freed by deleting the file, based on allocated blocks
+	Dev      uint64 // Device and inode, used to account for hard links
+	Ino      uint64
+	Nlink    uint64
+	Tier     int    // Priority tier of the target dir the file was found in (0 = drained first)
+	Tenant   string // Tenant subdirectory in fair share mode
+	Expired  bool   // Delete regardless of free space
+	IsDir    bool   // Path is a directory deleted as one unit
+	Cache    bool   // Found in a directory tagged with CACHEDIR.TAG
+	Keep     bool   // Marked with the user.vacuum.keep xattr, never deleted
+	Priority int    // From the user.vacuum.priority xattr; higher is deleted first
+
+	// Files deleted together as one unit. Size and Reclaim are their totals;
+	// empty for a single file.
+	Members []FileInfo
+}
+
+// fileID identifies a file independently of its path
+type fileID struct {
+	Dev uint64
+	Ino uint64
 }
 
-// CleanUp deletes oldest files in dirs until currentFreeBytes >= targetFreeBytes
-// It also removes any directories that become empty.
-func CleanUp(dirs []string, targetFreeBytes uint64, currentFreeBytes uint64, dryRun, humanReadable bool) error {
-	// 1. Collect all files from all directories
+// CleanOptions holds the per-location settings that control CleanUp
+type CleanOptions struct {
+	DryRun        bool
+	HumanReadable bool
+	Include       []string      // Only files matching one of these patterns are deleted
+	Exclude       []string      // Files and directories matching these patterns are never touched
+	MinAge        time.Duration // Files modified more recently than this are never deleted, whatever their age
+	MaxAge        time.Duration // Files older than this are deleted on every run
+	MaxSize       uint64        // Budget for the combined size of the target dirs (0 = unlimited)
+	MaxFiles      int           // Budget for the number of files in the target dirs (0 = unlimited)
+	VerifyEvery   int           // Re-check real free space with statfs every N deletions (0 = never)
+	CrossMounts   bool          // Descend into other filesystems mounted below the target dirs
+	SkipOpenFiles bool          // Never delete files that a process holds open
+	SkipLocked    bool          // Never delete files holding flock/fcntl advisory locks
+	AgeSource     string        // Timestamp used as the file's age: mtime (default), atime, ctime or btime
+
+	// Derive the age from a timestamp embedded in the file name: the first
+	// capture group of NameTimePattern (or the whole match) parsed with
+	// NameTimeLayout. Names that don't match use the file timestamp, or are
+	// skipped entirely with NameTimeSkip.
+	NameTimePattern string
+	NameTimeLayout  string
+	NameTimeSkip    bool
+
+	// Deletion order: oldest (default), largest or score. The score mode
+	// weighs age against size; both weights default to 1.
+	Order      string
+	AgeWeight  float64
+	SizeWeight float64
+
+	// Fair share mode: every immediate subdirectory of a target dir is a
+	// tenant, and files are deleted from whichever tenant uses the most space
+	// relative to its weight (keyed by subdirectory name, default 1).
+	FairShare     bool
+	TenantWeights map[string]float64
+
+	// Never delete the newest KeepLatest files of each directory, or of each
+	// group within a directory when GroupBy (a regex over the file name) is set.
+	KeepLatest int
+	GroupBy    string
+
+	// Grandfather-father-son retention; nil disables it
+	Retention *RetentionPolicy
+
+	// Delete whole directories instead of single files when Unit is
+	// "directory": every directory UnitDepth levels below a target dir is one
+	// unit, aged by its newest file or with UnitAge "mtime" by its own mtime.
+	Unit      string
+	UnitDepth int
+	UnitAge   string
+
+	// Directories tagged with CACHEDIR.TAG: with "only" their contents are the
+	// only candidates, with "prefer" they are deleted before anything else
+	CacheDirTag string
+
+	// Read user.vacuum.* extended attributes: expired files are deleted on
+	// every check, files marked keep are never deleted and the priority
+	// adjusts the deletion order. Costs at least a syscall per file.
+	Xattrs bool
+
+	// Delete companion files (subtitles, thumbnails, indexes) together with
+	// their primary file; nil disables it
+	Sidecar *SidecarRule
+
+	// Priority tiers of target dirs. Each tier is drained completely before
+	// the next is touched; dirs not listed form a final tier.
+	Tiers [][]string
+
+	// Truncate deleted files still held open by a process when free space is low.
+	// Used by checkAndClean before CleanUp runs.
+	TruncateDeletedOpen bool
+
+	// Inode state of the filesystem for this run, filled in by checkAndClean
+	TargetFreeInodes  uint64
+	CurrentFreeInodes uint64
+}
+
+// Supported values of CleanOptions.AgeSource
+const (
+	AgeSourceMtime = "mtime"
+	AgeSourceAtime = "atime"
+	AgeSourceCtime = "ctime"
+	AgeSourceBtime = "btime"
+)
+
+// defaultVerifyEvery is how many deletions happen between free space checks unless configured
+const defaultVerifyEvery = 100
+
+// runsEveryCheck reports whether CleanUp has work to do even when free space is sufficient
+func (o CleanOptions) runsEveryCheck() bool {
+	return o.MaxAge > 0 || o.MaxSize > 0 || o.MaxFiles > 0 || o.Xattrs || (o.Retention != nil && o.Retention.Always)
+}
+
+// CleanUp deletes oldest files in dirs until currentFreeBytes >= targetFreeBytes,
+// enough inodes are free and the dirs fit within opts.MaxSize and opts.MaxFiles,
+// as well as any files older than opts.MaxAge. It also removes any directories
+// that become empty.
+func CleanUp(dirs []string, targetFreeBytes uint64, currentFreeBytes uint64, opts CleanOptions) error {
+	dryRun, humanReadable := opts.DryRun, opts.HumanReadable
+
+	filter, err := newPathFilter(opts.Include, opts.Exclude)
+	if err != nil {
+		return err
+	}
+
+	nameTime, err := compileNameTimePattern(opts.NameTimePattern, opts.NameTimeLayout)
+	if err != nil {
+		return err
+	}
+
+	groupBy, err := compileGroupBy(opts.GroupBy)
+	if err != nil {
+		return err
+	}
+
+	sidecars, err := compileSidecarRule(opts.Sidecar)
+	if err != nil {
+		return err
+	}
+
+	// 1. Collect all files from all directories. A target dir nested in
+	// another one is walked on its own, so files are counted once and take
+	// the tier of the innermost dir.
+	dirs, roots := walkRoots(dirs)
 	var files []FileInfo
 
+	// Combined size of all managed files, for the MaxSize/MaxFiles budget
+	var totalBytes uint64
+	var totalFiles int
+
+	// Space used per tenant in fair share mode
+	tenantBytes := make(map[string]uint64)
+
+	// Files younger than MinAge are protected and never become candidates. In
+	// the unit modes whole units are protected once they are assembled.
+	var protectedCount int
+	var protectedBytes uint64
+	var young []FileInfo
+	minAgeCutoff := time.Now().Add(-opts.MinAge).Unix()
+
+	// Files whose configured age source is unavailable fall back to mtime
+	var fallbackCount int
+
+	// Files whose name carries no timestamp matching NameTimePattern
+	var nameMismatchCount int
+
+	// Files whose extended attributes couldn't be read or hold invalid values
+	var xattrFailures, xattrInvalid int
+	var xattrErr error
+	now := time.Now()
+
+	// Files of each unit directory, and files above unit_depth that belong to none
+	dirUnits := opts.Unit == UnitDirectory
+	groupUnits := dirUnits || sidecars != nil
+	unitMembers := make(map[string][]FileInfo)
+	var aboveUnitCount int
+
 	for _, dir := range dirs {
+		tier := tierOf(dir, opts.Tiers)
+		rootDev, rootDevOK := deviceOf(dir)
+		markers := newMarkerTree(dir)
+		var caches cacheDirTracker
 		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
 			if err != nil {
+				// Searching a tree like /home for tagged caches gets past
+				// other users' private homes and unreadable mounts
+				if opts.CacheDirTag == CacheDirTagOnly && path != dir {
+					fmt.Printf("Skipping %s: %v\n", path, err)
+					if d != nil && d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
 				return err
 			}
-			if !d.Type().IsRegular() {
+			if d.IsDir() && path != dir && !opts.CrossMounts && rootDevOK && isOtherDevice(d, rootDev) {
+				fmt.Printf("Skipping mount point %s (cross_mounts is disabled)\n", path)
+				return filepath.SkipDir
+			}
+			if d.IsDir() && path != dir && roots[filepath.Clean(path)] {
+				return filepath.SkipDir
+			}
+			if path != dir && (filter.Excluded(relPath(dir, path), d.IsDir()) || markers.Ignored(path, d.IsDir())) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() && markers.enter(path) {
+				fmt.Printf("Skipping %s (protected by %s)\n", path, keepFileName)
+				return filepath.SkipDir
+			}
+			inCache := opts.CacheDirTag != "" && caches.inCache(path, d.IsDir())
+			if !d.Type().IsRegular() || isMarkerFile(d.Name()) {
+				return nil
+			}
+			if (opts.CacheDirTag == CacheDirTagOnly && !inCache) || (inCache && d.Name() == cacheDirTagName) {
+				return nil
+			}
+			if !filter.Included(relPath(dir, path)) {
 				return nil
 			}
 
+			var unit string
+			if dirUnits {
+				var ok bool
+				if unit, ok = unitDir(dir, path, opts.UnitDepth); !ok {
+					aboveUnitCount++
+					return nil
+				}
+			}
+
 			info, err := d.Info()
 			if err != nil {
 				return nil // Skip files we can't stat
 			}
 
-			files = append(files, FileInfo{
-				Path: path,
-				Size: info.Size(),
-				Age:  info.ModTime().Unix(),
-			})
+			totalBytes += uint64(info.Size())
+			totalFiles++
+
+			var tenant string
+			if opts.FairShare {
+				tenant = tenantOf(dir, path)
+				tenantBytes[tenant] += uint64(info.Size())
+			}
+
+			var age int64
+			nameAge, nameOK := parseNameTime(nameTime, opts.NameTimeLayout, d.Name())
+			if nameTime != nil && !nameOK {
+				nameMismatchCount++
+				if opts.NameTimeSkip {
+					return nil
+				}
+			}
+			if nameOK {
+				age = nameAge
+			} else {
+				var ok bool
+				if age, ok = fileAge(path, info, opts.AgeSource); !ok {
+					fallbackCount++
+				}
+			}
+
+			// min_age protects recently written files even when their age
+			// comes from a name or a timestamp other than mtime, e.g. a
+			// restored file or a recording still in progress
+			written := info.ModTime().Unix()
+			if age > written {
+				written = age
+			}
+
+			if opts.MinAge > 0 && written > minAgeCutoff && !groupUnits {
+				protectedCount++
+				protectedBytes += uint64(info.Size())
+				if opts.KeepLatest > 0 || opts.Retention != nil {
+					young = append(young, FileInfo{Path: path, Age: age})
+				}
+				return nil
+			}
+
+			file := FileInfo{
+				Path:    path,
+				Size:    info.Size(),
+				Age:     age,
+				Written: written,
+				Reclaim: info.Size(),
+				Nlink:   1,
+				Tier:    tier,
+				Tenant:  tenant,
+				Cache:   inCache,
+			}
+			if st, ok := statFile(info); ok {
+				// Deleting a file frees its allocated blocks: less than the
+				// apparent size for sparse files, more for small files that
+				// still occupy a whole block.
+				file.Reclaim = st.Blocks * 512
+				file.Dev, file.Ino, file.Nlink = st.Dev, st.Ino, st.Nlink
+			}
+			if opts.Xattrs {
+				if attrs, err := vacuumXattrs(path); err != nil {
+					xattrFailures++
+					xattrErr = err
+				} else if !applyXattrs(&file, attrs, now) {
+					xattrInvalid++
+				}
+			}
+			if dirUnits {
+				unitMembers[unit] = append(unitMembers[unit], file)
+				return nil
+			}
+			files = append(files, file)
 			return nil
 		})
 		if err != nil {
@@ -46,69 +337,475 @@ func CleanUp(dirs []string, targetFreeBytes uint64, currentFreeBytes uint64, dry
 		}
 	}
 
-	// 2. Sort by Age ascending (oldest first)
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Age < files[j].Age
-	})
+	if nameMismatchCount > 0 {
+		if opts.NameTimeSkip {
+			fmt.Printf("Skipping %d files without a timestamp in their name\n", nameMismatchCount)
+		} else {
+			fmt.Printf("Using file timestamps for %d files without a timestamp in their name\n", nameMismatchCount)
+		}
+	}
 
-	// 3. Delete files until target reached
-	bytesNeeded := targetFreeBytes - currentFreeBytes
-	var bytesDeleted uint64 = 0
+	if fallbackCount > 0 {
+		fmt.Printf("Using mtime for %d files without a usable %s\n", fallbackCount, opts.AgeSource)
+	}
 
-	// Only delete if we actually need space
+	if xattrFailures > 0 {
+		fmt.Printf("Unable to read extended attributes of %d files: %v\n", xattrFailures, xattrErr)
+	}
+	if xattrInvalid > 0 {
+		fmt.Printf("Ignoring invalid %s or %s values on %d files\n", xattrExpires, xattrPriority, xattrInvalid)
+	}
+
+	// In directory mode the candidates are directories, and the protections
+	// below apply to them instead of single files
+	candidates := "files"
+	if dirUnits {
+		candidates = "directories"
+		if aboveUnitCount > 0 {
+			fmt.Printf("Skipping %d files above unit_depth %d\n", aboveUnitCount, opts.UnitDepth)
+		}
+		files = directoryUnits(unitMembers, opts.UnitAge)
+	}
+	if sidecars != nil {
+		files = sidecarUnits(files, sidecars)
+	}
+	if groupUnits && opts.MinAge > 0 {
+		var youngUnits []FileInfo
+		var youngBytes uint64
+		files, youngUnits, youngBytes = protectYoung(files, minAgeCutoff)
+		protectedCount += len(youngUnits)
+		protectedBytes += youngBytes
+		young = append(young, youngUnits...)
+	}
+
+	files, markedCount, markedBytes := protectMarked(files)
+	if markedCount > 0 {
+		fmt.Printf("Skipping %d %s marked %s (size: %s)\n",
+			markedCount, candidates, xattrKeep, sizeString(markedBytes, humanReadable))
+	}
+
+	files, keptCount, keptBytes := keepLatest(files, young, opts.KeepLatest, groupBy)
+	if keptCount > 0 {
+		fmt.Printf("Keeping the latest %d %s per group: %d %s protected (size: %s)\n",
+			opts.KeepLatest, candidates, keptCount, candidates, sizeString(keptBytes, humanReadable))
+	}
+
+	files, retainedCount, retainedBytes := applyRetention(files, young, opts.Retention, dryRun, humanReadable)
+
+	files = accountHardLinks(files, humanReadable)
+
+	if opts.SkipOpenFiles {
+		files = skipOpenFiles(files, humanReadable)
+	}
+
+	if protectedCount > 0 {
+		fmt.Printf("Skipping %d %s younger than min_age %v (size: %s)\n",
+			protectedCount, candidates, opts.MinAge, sizeString(protectedBytes, humanReadable))
+	}
+
+	// 2. Sort by deletion order (oldest first unless configured otherwise)
+	sortCandidates(files, opts, tenantBytes)
+
+	// 3. Delete files until target reached. Files older than MaxAge are
+	// deleted regardless of free space.
+	var bytesNeeded uint64
 	if currentFreeBytes < targetFreeBytes {
-		for _, file := range files {
-			if bytesDeleted >= bytesNeeded {
-				break
+		bytesNeeded = targetFreeBytes - currentFreeBytes
+	}
+	var overBudget uint64
+	if opts.MaxSize > 0 && totalBytes > opts.MaxSize {
+		overBudget = totalBytes - opts.MaxSize
+		fmt.Printf("Target directories hold %s, exceeding max_size %s\n",
+			sizeString(totalBytes, humanReadable), sizeString(opts.MaxSize, humanReadable))
+	}
+	var filesNeeded int
+	if opts.MaxFiles > 0 && totalFiles > opts.MaxFiles {
+		filesNeeded = totalFiles - opts.MaxFiles
+		fmt.Printf("Target directories hold %d files, exceeding max_files %d\n", totalFiles, opts.MaxFiles)
+	}
+	var inodesNeeded uint64
+	if opts.CurrentFreeInodes < opts.TargetFreeInodes {
+		inodesNeeded = opts.TargetFreeInodes - opts.CurrentFreeInodes
+	}
+
+	var bytesFreed uint64 = 0 // Reclaimed blocks, towards the free space target
+	var bytesExpected uint64  // Reclaimed blocks as computed, before verification
+	var sizeDeleted uint64    // Apparent size, towards the max_size budget
+	var filesDeleted int
+	var inodesFreed uint64
+	linksLeft := make(map[fileID]uint64)
+	maxAgeCutoff := time.Now().Add(-opts.MaxAge).Unix()
+
+	targetsReached := func() bool {
+		return bytesFreed >= bytesNeeded && sizeDeleted >= overBudget &&
+			filesDeleted >= filesNeeded && inodesFreed >= inodesNeeded
+	}
+
+	// Filesystems that free blocks lazily, and files held open by other
+	// processes, make the arithmetic above optimistic. Replace it with what
+	// statfs actually reports.
+	verify := opts.VerifyEvery > 0 && !dryRun && (bytesNeeded > 0 || inodesNeeded > 0)
+	verifyFreeSpace := func() bool {
+		usage, err := diskUsage(dirs[0])
+		if err != nil {
+			fmt.Printf("Failed to verify free space on %s: %v\n", dirs[0], err)
+			return false
+		}
+		bytesFreed = 0
+		if usage.Free > currentFreeBytes {
+			bytesFreed = usage.Free - currentFreeBytes
+		}
+		if opts.TargetFreeInodes > 0 {
+			inodesFreed = 0
+			if usage.FreeInodes > opts.CurrentFreeInodes {
+				inodesFreed = usage.FreeInodes - opts.CurrentFreeInodes
 			}
+		}
+		return true
+	}
 
-			sizeStr := fmt.Sprintf("%d", file.Size)
-			if humanReadable {
-				sizeStr = formatBytes(uint64(file.Size))
+	// Advisory locks are re-read before each deletion, so locks taken during
+	// a long pass are honored. A failed read is reported once.
+	var locked map[fileID]bool
+	var lockErr error
+
+	// Whether free space was checked since the last deletion, and whether
+	// candidates were left once the targets were reached
+	verified := true
+	var stoppedEarly bool
+
+	for _, file := range files {
+		expired := file.Expired || (opts.MaxAge > 0 && file.Age < maxAgeCutoff)
+		if !expired && targetsReached() && verify && !verified {
+			// Only stop once statfs agrees with the estimate
+			verifyFreeSpace()
+			verified = true
+		}
+		if !expired && targetsReached() {
+			stoppedEarly = true
+			continue
+		}
+
+		if opts.SkipLocked && lockErr == nil {
+			if locked, lockErr = lockedFiles(); lockErr != nil {
+				fmt.Printf("Unable to read advisory locks: %v\n", lockErr)
 			}
+		}
 
+		if anyMemberIn(file, locked) {
 			if dryRun {
-				fmt.Printf("[DRY RUN] Would delete %s (size: %s)\n", file.Path, sizeStr)
+				fmt.Printf("[DRY RUN] Would skip %s (holds an advisory lock)\n", file.Path)
 			} else {
-				err := os.Remove(file.Path)
-				if err != nil {
-					fmt.Printf("Failed to delete %s: %v\n", file.Path, err)
-					continue
+				fmt.Printf("Skipping %s (holds an advisory lock)\n", file.Path)
+			}
+			continue
+		}
+
+		deleted := []FileInfo{file}
+		if len(file.Members) > 0 {
+			deleted = deleteUnit(file, dryRun, humanReadable)
+		} else if dryRun {
+			fmt.Printf("[DRY RUN] Would delete %s (size: %s)\n", file.Path, sizeDetail(file, humanReadable))
+		} else {
+			err := os.Remove(file.Path)
+			if err != nil {
+				fmt.Printf("Failed to delete %s: %v\n", file.Path, err)
+				continue
+			}
+			fmt.Printf("Deleted %s (size: %s)\n", file.Path, sizeDetail(file, humanReadable))
+		}
+
+		for _, f := range deleted {
+			bytesFreed += uint64(f.Reclaim)
+			bytesExpected += uint64(f.Reclaim)
+			sizeDeleted += uint64(f.Size)
+			filesDeleted++
+
+			// An inode is only released once its last link is gone
+			if f.Nlink <= 1 {
+				inodesFreed++
+			} else {
+				id := fileID{f.Dev, f.Ino}
+				if _, seen := linksLeft[id]; !seen {
+					linksLeft[id] = f.Nlink
+				}
+				linksLeft[id]--
+				if linksLeft[id] == 0 {
+					inodesFreed++
 				}
-				fmt.Printf("Deleted %s (size: %s)\n", file.Path, sizeStr)
 			}
-			bytesDeleted += uint64(file.Size)
+
+			verified = false
+			if verify && filesDeleted%opts.VerifyEvery == 0 {
+				verifyFreeSpace()
+				verified = true
+			}
+		}
+	}
+
+	if verify && filesDeleted > 0 && verifyFreeSpace() {
+		if bytesFreed < bytesExpected {
+			fmt.Printf("Expected to free %s but the filesystem reports %s freed (gap: %s)\n",
+				sizeString(bytesExpected, humanReadable), sizeString(bytesFreed, humanReadable),
+				sizeString(bytesExpected-bytesFreed, humanReadable))
+		} else {
+			fmt.Printf("Expected to free %s, the filesystem reports %s freed\n",
+				sizeString(bytesExpected, humanReadable), sizeString(bytesFreed, humanReadable))
 		}
 	}
 
 	// 4. Remove empty directories
 	for _, dir := range dirs {
-		if err := removeEmptyDirs(dir, dryRun); err != nil {
+		if err := removeEmptyDirs(dir, roots, filter, opts); err != nil {
 			fmt.Printf("Error removing empty directories in %s: %v\n", dir, err)
 		}
 	}
 
-	if currentFreeBytes < targetFreeBytes && bytesDeleted < bytesNeeded {
-		needed := bytesNeeded - bytesDeleted
-		neededStr := fmt.Sprintf("%d bytes", needed)
-		if humanReadable {
-			neededStr = formatBytes(needed)
+	// Free space can be taken again between the last check and the end
+	if stoppedEarly && bytesFreed < bytesNeeded {
+		return fmt.Errorf("stopped with candidates left but the filesystem still needs %s",
+			sizeString(bytesNeeded-bytesFreed, humanReadable))
+	}
+	if stoppedEarly && inodesFreed < inodesNeeded {
+		return fmt.Errorf("stopped with candidates left but the filesystem still needs %d free inodes",
+			inodesNeeded-inodesFreed)
+	}
+
+	if bytesFreed < bytesNeeded {
+		needed := bytesNeeded - bytesFreed
+		msg := fmt.Sprintf("deleted all eligible files but still need %s", sizeString(needed, humanReadable))
+		if protectedCount > 0 {
+			msg += fmt.Sprintf("; remaining %d %s (%s) are younger than min_age %v",
+				protectedCount, candidates, sizeString(protectedBytes, humanReadable), opts.MinAge)
+		}
+		if keptCount > 0 {
+			msg += fmt.Sprintf("; %d %s (%s) are protected by keep_latest",
+				keptCount, candidates, sizeString(keptBytes, humanReadable))
+		}
+		if retainedCount > 0 {
+			msg += fmt.Sprintf("; %d files (%s) are kept by the retention policy",
+				retainedCount, sizeString(retainedBytes, humanReadable))
+		}
+		if markedCount > 0 {
+			msg += fmt.Sprintf("; %d %s (%s) are marked %s",
+				markedCount, candidates, sizeString(markedBytes, humanReadable), xattrKeep)
 		}
-		return fmt.Errorf("deleted all eligible files but still need %s", neededStr)
+		return errors.New(msg)
+	}
+
+	if sizeDeleted < overBudget {
+		return fmt.Errorf("deleted all eligible files but still %s over max_size", sizeString(overBudget-sizeDeleted, humanReadable))
+	}
+
+	if inodesFreed < inodesNeeded {
+		return fmt.Errorf("deleted all eligible files but still need %d free inodes", inodesNeeded-inodesFreed)
+	}
+
+	if filesDeleted < filesNeeded {
+		return fmt.Errorf("deleted all eligible files but still %d files over max_files %d", filesNeeded-filesDeleted, opts.MaxFiles)
 	}
 
 	return nil
 }
 
-func removeEmptyDirs(root string, dryRun bool) error {
+// sizeDetail formats the size of a deletion candidate for the log, along with
+// the space it actually reclaims when that is less
+func sizeDetail(f FileInfo, humanReadable bool) string {
+	sizeStr := fmt.Sprintf("%d", f.Size)
+	if humanReadable {
+		sizeStr = formatBytes(uint64(f.Size))
+	}
+	if f.Reclaim < f.Size {
+		sizeStr += ", reclaims: " + sizeString(uint64(f.Reclaim), humanReadable)
+	}
+	return sizeStr
+}
+
+// fileAge returns the Unix timestamp a file's age is measured from. It falls
+// back to the modification time, reporting false, when the requested source is
+// not available on this platform or filesystem.
+func fileAge(path string, info os.FileInfo, source string) (int64, bool) {
+	switch source {
+	case "", AgeSourceMtime:
+		return info.ModTime().Unix(), true
+	case AgeSourceAtime, AgeSourceCtime:
+		if st, ok := statFile(info); ok {
+			if source == AgeSourceAtime {
+				return st.Atime, true
+			}
+			return st.Ctime, true
+		}
+	case AgeSourceBtime:
+		if btime, ok := birthTime(path); ok && btime > 0 {
+			return btime, true
+		}
+	}
+	return info.ModTime().Unix(), false
+}
+
+// compileNameTimePattern compiles a name_time_pattern, returning nil when unset
+func compileNameTimePattern(pattern, layout string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if layout == "" {
+		return nil, fmt.Errorf("name_time_pattern requires name_time_layout")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name_time_pattern: %w", err)
+	}
+	return re, nil
+}
+
+// parseNameTime extracts the timestamp embedded in a file name. It reports
+// false if the name doesn't match or the matched text doesn't parse.
+func parseNameTime(re *regexp.Regexp, layout, name string) (int64, bool) {
+	if re == nil {
+		return 0, false
+	}
+	m := re.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	value := m[0]
+	if len(m) > 1 {
+		value = m[1]
+	}
+	t, err := time.ParseInLocation(layout, value, time.Local)
+	if err != nil {
+		return 0, false
+	}
+	return t.Unix(), true
+}
+
+// validateAgeSource checks an age_source setting
+func validateAgeSource(source string) error {
+	switch source {
+	case "", AgeSourceMtime, AgeSourceAtime, AgeSourceCtime, AgeSourceBtime:
+		return nil
+	}
+	return fmt.Errorf("invalid age_source %q (expected mtime, atime, ctime or btime)", source)
+}
+
+// skipOpenFiles drops files held open by any process. Deleting them frees no
+// space and can break the process writing them.
+func skipOpenFiles(files []FileInfo, humanReadable bool) []FileInfo {
+	open, err := openFiles()
+	if err != nil {
+		fmt.Printf("Unable to detect open files: %v\n", err)
+		return files
+	}
+
+	var skipped int
+	var skippedBytes uint64
+	kept := files[:0]
+	for _, f := range files {
+		if anyMemberIn(f, open) {
+			skipped++
+			skippedBytes += uint64(f.Reclaim)
+			continue
+		}
+		kept = append(kept, f)
+	}
+
+	if skipped > 0 {
+		fmt.Printf("Skipping %d files currently open by a process (holding %s)\n",
+			skipped, sizeString(skippedBytes, humanReadable))
+	}
+	return kept
+}
+
+// accountHardLinks adjusts the reclaimable space of hard-linked files. Unlinking
+// a file frees nothing while other links remain, so files with links outside the
+// candidate set are dropped, and the space of files whose links are all
+// candidates is split evenly between the links.
+func accountHardLinks(files []FileInfo, humanReadable bool) []FileInfo {
+	found := make(map[fileID]uint64)
+	for _, f := range files {
+		for _, m := range f.memberFiles() {
+			if m.Nlink > 1 {
+				found[fileID{m.Dev, m.Ino}]++
+			}
+		}
+	}
+	if len(found) == 0 {
+		return files
+	}
+
+	var skipped int
+	var skippedBytes uint64
+	kept := files[:0]
+	for _, f := range files {
+		if len(f.Members) > 0 {
+			// A unit is deleted as a whole, so members with links elsewhere
+			// are removed anyway but reclaim nothing
+			f.Reclaim = 0
+			for i := range f.Members {
+				m := &f.Members[i]
+				if m.Nlink > 1 {
+					if found[fileID{m.Dev, m.Ino}] < m.Nlink {
+						m.Reclaim = 0
+					} else {
+						m.Reclaim /= int64(m.Nlink)
+					}
+				}
+				f.Reclaim += m.Reclaim
+			}
+			kept = append(kept, f)
+			continue
+		}
+		if f.Nlink > 1 {
+			if found[fileID{f.Dev, f.Ino}] < f.Nlink {
+				skipped++
+				skippedBytes += uint64(f.Size)
+				continue
+			}
+			f.Reclaim /= int64(f.Nlink)
+		}
+		kept = append(kept, f)
+	}
+
+	if skipped > 0 {
+		fmt.Printf("Skipping %d hard-linked files with links outside the target directories (size: %s)\n",
+			skipped, sizeString(skippedBytes, humanReadable))
+	}
+	return kept
+}
+
+func removeEmptyDirs(root string, roots map[string]bool, filter *pathFilter, opts CleanOptions) error {
 	var dirs []string
+	dryRun := opts.DryRun
+	rootDev, rootDevOK := deviceOf(root)
+	markers := newMarkerTree(root)
+	var caches cacheDirTracker
 
-	// Collect all directories
+	// Collect all directories, leaving excluded and protected subtrees, other
+	// filesystems and nested target dirs alone, and in cachedir_tag "only"
+	// mode untagged ones
 	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil // Ignore errors accessing paths
 		}
-		if d.IsDir() && path != root {
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root {
+			if roots[filepath.Clean(path)] {
+				return filepath.SkipDir
+			}
+			if !opts.CrossMounts && rootDevOK && isOtherDevice(d, rootDev) {
+				return filepath.SkipDir
+			}
+			if filter.Excluded(relPath(root, path), true) || markers.Ignored(path, true) {
+				return filepath.SkipDir
+			}
+		}
+		if markers.enter(path) {
+			return filepath.SkipDir
+		}
+		inCache := opts.CacheDirTag == CacheDirTagOnly && caches.inCache(path, true)
+		if path != root && (opts.CacheDirTag != CacheDirTagOnly || inCache) {
 			dirs = append(dirs, path)
 		}
 		return nil
@@ -146,6 +843,52 @@ func removeEmptyDirs(root string, dryRun bool) error {
 	return nil
 }
 
+// walkRoots drops repeated target dirs and returns the remaining ones along
+// with the set of their cleaned paths, which walks use to skip nested dirs
+func walkRoots(dirs []string) ([]string, map[string]bool) {
+	var distinct []string
+	roots := make(map[string]bool)
+	for _, d := range dirs {
+		if c := filepath.Clean(d); !roots[c] {
+			roots[c] = true
+			distinct = append(distinct, d)
+		}
+	}
+	return distinct, roots
+}
+
+// deviceOf returns the device the path lives on, if the platform reports it
+func deviceOf(path string) (uint64, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := statFile(info)
+	return st.Dev, ok
+}
+
+// isOtherDevice reports whether the directory entry is on a different device
+// than rootDev, i.e. whether it is a mount point below the walked root.
+func isOtherDevice(d os.DirEntry, rootDev uint64) bool {
+	dev, ok := entryDevice(d)
+	return ok && dev != rootDev
+}
+
+// diskUsage reports the usage of the filesystem holding a path. Tests replace
+// it to simulate filesystems that free blocks lazily.
+var diskUsage = GetDiskUsage
+
+// entryDevice returns the device of a directory entry. Tests replace it to
+// simulate mount points.
+var entryDevice = func(d os.DirEntry) (uint64, bool) {
+	info, err := d.Info()
+	if err != nil {
+		return 0, false
+	}
+	st, ok := statFile(info)
+	return st.Dev, ok
+}
+
 func isDirEmpty(name string) (bool, error) {
 	f, err := os.Open(name)
 	if err != nil {
@@ -26,7 +26,7 @@ func TestCleanUpDryRun(t *testing.T) {
 	targetFree := uint64(1000)
 	currentFree := uint64(0)
 
-	err = CleanUp([]string{tempDir}, targetFree, currentFree, true, false)
+	err = CleanUp([]string{tempDir}, targetFree, currentFree, CleanOptions{DryRun: true})
 	if err != nil && err.Error()[:28] != "deleted all eligible files b" {
 		t.Fatalf("CleanUp failed with unexpected error: %v", err)
 	}
@@ -1,12 +1,32 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
 
+// allocated returns the space the files occupy, which is what deleting them frees
+func allocated(t *testing.T, paths ...string) uint64 {
+	t.Helper()
+	var total uint64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st, ok := statFile(info); ok {
+			total += uint64(st.Blocks * 512)
+		} else {
+			total += uint64(info.Size())
+		}
+	}
+	return total
+}
+
 func TestCleanUp(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir, err := os.MkdirTemp("", "cleaner_test")
@@ -45,18 +65,15 @@ func TestCleanUp(t *testing.T) {
 		}
 	}
 
-	// Total size: 600.
-	// Target free: 150.
-	// Need to delete file1 (100) and file2 (200) to reach target free?
-	// Wait, logic is: delete until currentFree >= targetFree.
-	// Start free: 0. Target: 150.
-	// Delete file1 (100) -> free 100.
-	// Delete file2 (200) -> free 300. Stop.
+	// Delete until currentFree >= targetFree, counting the blocks each file
+	// occupies. Start free: 0. Target: the space of file1 and file2.
+	// Delete file1 -> not enough yet.
+	// Delete file2 -> target reached. Stop.
 
-	targetFree := uint64(150)
+	targetFree := allocated(t, filepath.Join(tempDir, "file1.txt"), filepath.Join(tempDir, "subdir/file2.txt"))
 	currentFree := uint64(0)
 
-	err = CleanUp([]string{tempDir}, targetFree, currentFree, false, false)
+	err = CleanUp([]string{tempDir}, targetFree, currentFree, CleanOptions{})
 	if err != nil {
 		t.Fatalf("CleanUp failed: %v", err)
 	}
@@ -112,14 +129,12 @@ func TestCleanUp_MultipleDirectories(t *testing.T) {
 		}
 	}
 
-	// Total size: 300.
-	// Target free: 150 (needs to free 150+ bytes).
+	// Target free: the space of two files.
 	// Start free: 0.
-	// Should delete old.txt (100) -> free 100.
-	// Should delete mid.txt (100) -> free 200. Stop.
+	// Should delete old.txt, then mid.txt. Stop.
 	// new.txt should remain.
 
-	err := CleanUp([]string{dir1, dir2}, 150, 0, false, false)
+	err := CleanUp([]string{dir1, dir2}, allocated(t, files[0].path, files[1].path), 0, CleanOptions{})
 	if err != nil {
 		t.Fatalf("CleanUp failed: %v", err)
 	}
@@ -134,3 +149,686 @@ func TestCleanUp_MultipleDirectories(t *testing.T) {
 		t.Errorf("new.txt should exist")
 	}
 }
+
+func TestCleanUp_IncludeExclude(t *testing.T) {
+	tempDir := t.TempDir()
+	current := filepath.Join(tempDir, "current")
+	if err := os.Mkdir(current, 0755); err != nil {
+		t.Fatal(err)
+	}
+	emptyKept := filepath.Join(tempDir, "current", "empty")
+	if err := os.Mkdir(emptyKept, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{"a.log.gz", "b.mp4", "c.lock", "d.txt", "current/e.mp4"}
+	for i, name := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-time.Duration(10-i) * time.Hour)
+		if err := os.Chtimes(path, time.Now(), modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := CleanOptions{
+		Include: []string{"*.log.gz", "*.mp4", "*.lock"},
+		Exclude: []string{"*.lock", "current/"},
+	}
+
+	// Ask for more than is available so every eligible file is deleted
+	err := CleanUp([]string{tempDir}, 10000, 0, opts)
+	if err == nil {
+		t.Fatalf("Expected error since target cannot be reached")
+	}
+
+	for _, name := range []string{"a.log.gz", "b.mp4"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+	for _, name := range []string{"c.lock", "d.txt", "current/e.mp4"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+	if _, err := os.Stat(emptyKept); err != nil {
+		t.Errorf("empty directory inside excluded tree should still exist")
+	}
+}
+
+func TestCleanUp_MinAge(t *testing.T) {
+	tempDir := t.TempDir()
+
+	oldFile := filepath.Join(tempDir, "old.ts")
+	newFile := filepath.Join(tempDir, "recording.ts")
+	for _, path := range []string{oldFile, newFile} {
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(oldFile, time.Now(), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Needs both files, but the new one is protected
+	err := CleanUp([]string{tempDir}, allocated(t, oldFile, newFile), 0, CleanOptions{MinAge: time.Hour})
+	if err == nil || !strings.Contains(err.Error(), "min_age") {
+		t.Fatalf("Expected min_age error, got %v", err)
+	}
+
+	if _, err := os.Stat(oldFile); !os.IsNotExist(err) {
+		t.Errorf("old.ts should have been deleted")
+	}
+	if _, err := os.Stat(newFile); err != nil {
+		t.Errorf("recording.ts should be protected by min_age")
+	}
+}
+
+func TestCleanUp_MaxAge(t *testing.T) {
+	tempDir := t.TempDir()
+
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"expired1.log", 72 * time.Hour},
+		{"expired2.log", 50 * time.Hour},
+		{"fresh.log", 1 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(tempDir, f.name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Plenty of free space: only retention applies
+	err := CleanUp([]string{tempDir}, 0, 1000, CleanOptions{MaxAge: 48 * time.Hour})
+	if err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+
+	for _, name := range []string{"expired1.log", "expired2.log"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "fresh.log")); err != nil {
+		t.Errorf("fresh.log should still exist")
+	}
+}
+
+func TestCleanUp_SizeBudget(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var paths []string
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("file%d.dat", i))
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Duration(5-i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	// 500 bytes on disk, budget of 250 bytes: the three oldest files must go
+	err := CleanUp([]string{tempDir}, 0, 1000, CleanOptions{MaxSize: 250})
+	if err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		if i < 3 && !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", path)
+		}
+		if i >= 3 && err != nil {
+			t.Errorf("%s should still exist", path)
+		}
+	}
+
+	// File count budget: keep only the newest file
+	err = CleanUp([]string{tempDir}, 0, 1000, CleanOptions{MaxFiles: 1})
+	if err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, err := os.Stat(paths[3]); !os.IsNotExist(err) {
+		t.Errorf("%s should have been deleted", paths[3])
+	}
+	if _, err := os.Stat(paths[4]); err != nil {
+		t.Errorf("%s should still exist", paths[4])
+	}
+}
+
+func TestCleanUp_Inodes(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("cache%d", i))
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Duration(4-i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	// Bytes are fine but two inodes are needed
+	opts := CleanOptions{TargetFreeInodes: 10, CurrentFreeInodes: 8}
+	if err := CleanUp([]string{tempDir}, 0, 1000, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		if i < 2 && !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", path)
+		}
+		if i >= 2 && err != nil {
+			t.Errorf("%s should still exist", path)
+		}
+	}
+}
+
+func TestCleanUp_BlockAccounting(t *testing.T) {
+	tempDir := t.TempDir()
+	target := filepath.Join(tempDir, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sparse file reports 1MB but occupies almost nothing
+	sparse := filepath.Join(target, "sparse.img")
+	f, err := os.Create(sparse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// A hard-linked file whose other link lives outside the target dir
+	linked := filepath.Join(target, "linked.dat")
+	if err := os.WriteFile(linked, make([]byte, 8192), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(linked, filepath.Join(tempDir, "outside.dat")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	for _, path := range []string{sparse, linked} {
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 512KB cannot be reclaimed: the sparse file frees at most a few blocks and
+	// the hard-linked file frees nothing
+	err = CleanUp([]string{target}, 512*1024, 0, CleanOptions{})
+	if err == nil {
+		t.Fatalf("Expected error since sparse and hard-linked files free no space")
+	}
+	if _, err := os.Stat(sparse); !os.IsNotExist(err) {
+		t.Errorf("sparse.img should have been deleted")
+	}
+	if _, err := os.Stat(linked); err != nil {
+		t.Errorf("linked.dat should be skipped because its other link is outside the target")
+	}
+}
+
+func TestCleanUp_VerifyFreeSpace(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for i, name := range []string{"first.dat", "second.dat"} {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Duration(2-i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// By arithmetic both files are needed, but statfs reports far more than
+	// 150 bytes free after the first deletion, so cleanup stops there.
+	err := CleanUp([]string{tempDir}, 150, 0, CleanOptions{VerifyEvery: 1})
+	if err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "first.dat")); !os.IsNotExist(err) {
+		t.Errorf("first.dat should have been deleted")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "second.dat")); err != nil {
+		t.Errorf("second.dat should still exist")
+	}
+}
+
+func TestCleanUp_VerifyLazyFree(t *testing.T) {
+	tempDir := t.TempDir()
+
+	names := []string{"a.dat", "b.dat", "c.dat", "d.dat"}
+	for i, name := range names {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Duration(len(names)-i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The filesystem only reports space freed once two files are gone
+	orig := diskUsage
+	diskUsage = func(path string) (DiskUsage, error) {
+		entries, err := os.ReadDir(tempDir)
+		if err != nil {
+			return DiskUsage{}, err
+		}
+		if len(names)-len(entries) < 2 {
+			return DiskUsage{}, nil
+		}
+		return DiskUsage{Free: 1 << 20}, nil
+	}
+	defer func() { diskUsage = orig }()
+
+	// The first deletion covers the 50 bytes by arithmetic, but statfs
+	// disagrees, so cleanup goes on to the next candidate
+	if err := CleanUp([]string{tempDir}, 50, 0, CleanOptions{VerifyEvery: defaultVerifyEvery}); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(tempDir, name))
+		if i < 2 && !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", name)
+		}
+		if i >= 2 && err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+}
+
+func TestCleanUp_AgeSourceAtime(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// recentlyRead was written long ago but accessed recently; neverRead is the reverse
+	recentlyRead := filepath.Join(tempDir, "recently-read.bin")
+	neverRead := filepath.Join(tempDir, "never-read.bin")
+	times := map[string][2]time.Time{
+		recentlyRead: {time.Now().Add(-time.Hour), time.Now().Add(-48 * time.Hour)},
+		neverRead:    {time.Now().Add(-24 * time.Hour), time.Now().Add(-2 * time.Hour)},
+	}
+	for path, tm := range times {
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, tm[0], tm[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := CleanUp([]string{tempDir}, 50, 0, CleanOptions{AgeSource: AgeSourceAtime})
+	if err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, err := os.Stat(neverRead); !os.IsNotExist(err) {
+		t.Errorf("never-read.bin has the oldest atime and should have been deleted")
+	}
+	if _, err := os.Stat(recentlyRead); err != nil {
+		t.Errorf("recently-read.bin should still exist")
+	}
+}
+
+func TestCleanUp_AgeSourceMinAge(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// fresh was just written, but its atime claims it is two days old
+	fresh := filepath.Join(tempDir, "fresh.bin")
+	stale := filepath.Join(tempDir, "stale.bin")
+	times := map[string][2]time.Time{
+		fresh: {time.Now().Add(-48 * time.Hour), time.Now()},
+		stale: {time.Now().Add(-24 * time.Hour), time.Now().Add(-24 * time.Hour)},
+	}
+	for path, tm := range times {
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, tm[0], tm[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// min_age is measured against the write time, whatever the age source
+	opts := CleanOptions{AgeSource: AgeSourceAtime, MinAge: time.Hour}
+	if err := CleanUp([]string{tempDir}, allocated(t, fresh, stale), 0, opts); err == nil {
+		t.Errorf("Expected error since fresh.bin is protected by min_age")
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale.bin should have been deleted")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh.bin should still exist")
+	}
+}
+
+func TestValidateAgeSource(t *testing.T) {
+	for _, source := range []string{"", "mtime", "atime", "ctime", "btime"} {
+		if err := validateAgeSource(source); err != nil {
+			t.Errorf("validateAgeSource(%q) returned unexpected error: %v", source, err)
+		}
+	}
+	if err := validateAgeSource("birth"); err == nil {
+		t.Errorf("validateAgeSource(%q) should have returned an error", "birth")
+	}
+}
+
+func TestParseNameTime(t *testing.T) {
+	re, err := compileNameTimePattern(`_(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2})\.`, "2006-01-02T15-04-05")
+	if err != nil {
+		t.Fatalf("compileNameTimePattern failed: %v", err)
+	}
+
+	got, ok := parseNameTime(re, "2006-01-02T15-04-05", "cam1_2024-05-01T13-00-00.mp4")
+	expected := time.Date(2024, 5, 1, 13, 0, 0, 0, time.Local).Unix()
+	if !ok || got != expected {
+		t.Errorf("parseNameTime = %d, %v; want %d, true", got, ok, expected)
+	}
+	if _, ok := parseNameTime(re, "2006-01-02T15-04-05", "cam1_latest.mp4"); ok {
+		t.Errorf("parseNameTime should not match a name without a timestamp")
+	}
+
+	if _, err := compileNameTimePattern(`(\d+`, "2006"); err == nil {
+		t.Errorf("Expected error for invalid regex")
+	}
+	if _, err := compileNameTimePattern(`(\d+)`, ""); err == nil {
+		t.Errorf("Expected error for missing layout")
+	}
+}
+
+func TestCleanUp_NameTime(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Names disagree with mtimes, which were reset by a restore
+	names := []string{"app.2024-05-03.log.gz", "app.2024-05-01.log.gz", "app.current.log"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(tempDir, name), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(tempDir, names[0]), time.Now(), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CleanOptions{
+		NameTimePattern: `\.(\d{4}-\d{2}-\d{2})\.`,
+		NameTimeLayout:  "2006-01-02",
+		NameTimeSkip:    true,
+	}
+	if err := CleanUp([]string{tempDir}, 50, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, names[1])); !os.IsNotExist(err) {
+		t.Errorf("%s is the oldest by name and should have been deleted", names[1])
+	}
+	for _, name := range []string{names[0], names[2]} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+}
+
+func TestCleanUp_NameTimeMinAge(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// restored was copied back a moment ago; its name dates it to 2024
+	restored := filepath.Join(tempDir, "cam1_2024-05-01T13-00-00.mp4")
+	old := filepath.Join(tempDir, "cam1_2024-05-02T13-00-00.mp4")
+	for _, path := range []string{restored, old} {
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(old, time.Now(), time.Now().Add(-24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := CleanOptions{
+		NameTimePattern: `_(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2})\.`,
+		NameTimeLayout:  "2006-01-02T15-04-05",
+		MinAge:          time.Hour,
+	}
+	if err := CleanUp([]string{tempDir}, allocated(t, restored, old), 0, opts); err == nil {
+		t.Errorf("Expected error since the restored file is protected by min_age")
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("%s should have been deleted", old)
+	}
+	if _, err := os.Stat(restored); err != nil {
+		t.Errorf("%s was written just now and should still exist", restored)
+	}
+}
+
+func TestCleanUp_Tiers(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := filepath.Join(tempDir, "cache")
+	recordings := filepath.Join(tempDir, "recordings")
+	for _, dir := range []string{cache, recordings} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []struct {
+		path string
+		age  time.Duration
+	}{
+		{filepath.Join(recordings, "old.mp4"), 48 * time.Hour},
+		{filepath.Join(cache, "a.bin"), 2 * time.Hour},
+		{filepath.Join(cache, "b.bin"), 1 * time.Hour},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.path, time.Now(), time.Now().Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The cache tier is drained completely before the older recording is touched
+	opts := CleanOptions{Tiers: [][]string{{cache}, {recordings}}}
+	if err := CleanUp([]string{recordings, cache}, allocated(t, files[1].path, files[2].path), 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	for _, f := range files[1:] {
+		if _, err := os.Stat(f.path); !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", f.path)
+		}
+	}
+	if _, err := os.Stat(files[0].path); err != nil {
+		t.Errorf("%s should still exist", files[0].path)
+	}
+}
+
+func TestCleanUp_NestedTier(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := filepath.Join(tempDir, "cache")
+	if err := os.Mkdir(cache, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		path string
+		age  time.Duration
+	}{
+		{filepath.Join(tempDir, "old.dat"), 48 * time.Hour},
+		{filepath.Join(cache, "new.bin"), 1 * time.Hour},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.path, time.Now(), time.Now().Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The cache is walked once, in its own tier, so the dirs hold 200 bytes
+	opts := CleanOptions{MaxSize: 200, Tiers: [][]string{{cache}}}
+	if err := CleanUp([]string{tempDir, cache}, 0, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f.path); err != nil {
+			t.Fatalf("%s should still exist within max_size", f.path)
+		}
+	}
+
+	opts.MaxSize = 150
+	if err := CleanUp([]string{tempDir, cache}, 0, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, err := os.Stat(files[1].path); !os.IsNotExist(err) {
+		t.Errorf("%s should have been deleted", files[1].path)
+	}
+	if _, err := os.Stat(files[0].path); err != nil {
+		t.Errorf("%s should still exist", files[0].path)
+	}
+	if _, err := os.Stat(cache); err != nil {
+		t.Errorf("Target dir %s should not be removed", cache)
+	}
+}
+
+func TestCleanUp_FairShare(t *testing.T) {
+	tempDir := t.TempDir()
+	heavy := filepath.Join(tempDir, "heavy")
+	quiet := filepath.Join(tempDir, "quiet")
+	for _, dir := range []string{heavy, quiet} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []struct {
+		path string
+		age  time.Duration
+	}{
+		{filepath.Join(quiet, "only.dat"), 10 * time.Hour},
+		{filepath.Join(heavy, "a.dat"), 3 * time.Hour},
+		{filepath.Join(heavy, "b.dat"), 2 * time.Hour},
+		{filepath.Join(heavy, "c.dat"), 1 * time.Hour},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.path, time.Now(), time.Now().Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Oldest-first would delete the quiet tenant's only file. With fair share
+	// the heavy tenant loses its two oldest files instead.
+	if err := CleanUp([]string{tempDir}, allocated(t, files[1].path, files[2].path), 0, CleanOptions{FairShare: true}); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	for i, f := range files {
+		_, err := os.Stat(f.path)
+		if (i == 1 || i == 2) && !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", f.path)
+		}
+		if (i == 0 || i == 3) && err != nil {
+			t.Errorf("%s should still exist", f.path)
+		}
+	}
+}
+
+func TestIsOtherDevice(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tempDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rootDev, ok := deviceOf(tempDir)
+	if !ok {
+		t.Skip("Platform does not report devices")
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isOtherDevice(entries[0], rootDev) {
+		t.Errorf("Expected %s to be on the same device as its parent", entries[0].Name())
+	}
+
+	// /proc is its own filesystem on Linux
+	procDev, procOK := deviceOf("/proc")
+	slashDev, slashOK := deviceOf("/")
+	if !procOK || !slashOK || procDev == slashDev {
+		return
+	}
+	entries, err = os.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range entries {
+		if d.Name() == "proc" && !isOtherDevice(d, slashDev) {
+			t.Errorf("Expected /proc to be detected as a mount point")
+		}
+	}
+}
+
+func TestCleanUp_CrossMounts(t *testing.T) {
+	rootDev, ok := deviceOf(os.TempDir())
+	if !ok {
+		t.Skip("Platform does not report devices")
+	}
+
+	// Pretend every directory named "mnt" is another filesystem
+	orig := entryDevice
+	entryDevice = func(d os.DirEntry) (uint64, bool) {
+		if d.Name() == "mnt" {
+			return rootDev + 1, true
+		}
+		return orig(d)
+	}
+	defer func() { entryDevice = orig }()
+
+	for _, crossMounts := range []bool{false, true} {
+		tempDir := t.TempDir()
+		mounted := filepath.Join(tempDir, "mnt", "old.dat")
+		local := filepath.Join(tempDir, "data", "old.dat")
+		for _, path := range []string{mounted, local} {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		CleanUp([]string{tempDir}, allocated(t, mounted, local), 0, CleanOptions{CrossMounts: crossMounts})
+
+		if _, err := os.Stat(local); !os.IsNotExist(err) {
+			t.Errorf("cross_mounts=%v: %s should have been deleted", crossMounts, local)
+		}
+		_, err := os.Stat(mounted)
+		if !crossMounts && err != nil {
+			t.Errorf("cross_mounts=false: files below a mount point should be left alone")
+		}
+		if crossMounts && !os.IsNotExist(err) {
+			t.Errorf("cross_mounts=true: %s should have been deleted", mounted)
+		}
+		if _, err := os.Stat(filepath.Dir(mounted)); !crossMounts && err != nil {
+			t.Errorf("cross_mounts=false: the mount point must not be removed")
+		}
+	}
+}
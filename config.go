@@ -23,15 +23,101 @@ type GlobalConfig struct {
 	HumanReadable  bool     `toml:"human_readable"`
 	MinFreePercent float64  `toml:"min_free_percent"`
 	MinFreeBytes   byteSize `toml:"min_free_bytes"`
+
+	MinFreeInodesPercent float64 `toml:"min_free_inodes_percent"`
+	MinFreeInodes        uint64  `toml:"min_free_inodes"`
 }
 
 // LocationConfig defines a specific partition to monitor and directories to clean
 type LocationConfig struct {
 	TargetDirs     []string  `toml:"target_dirs"`
+	Preset         string    `toml:"preset"`           // Built-in location for a well-known cache, see -presets
 	MinFreePercent *float64  `toml:"min_free_percent"` // Optional override
 	MinFreeBytes   *byteSize `toml:"min_free_bytes"`   // Optional override
 	CheckInterval  *duration `toml:"check_interval"`   // Optional override
 	DryRun         *bool     `toml:"dry_run"`          // Optional override
+
+	MinFreeInodesPercent *float64 `toml:"min_free_inodes_percent"` // Optional override
+	MinFreeInodes        *uint64  `toml:"min_free_inodes"`         // Optional override
+
+	Include  []string `toml:"include"`   // Glob patterns of files that may be deleted
+	Exclude  []string `toml:"exclude"`   // Glob patterns of files and directories to keep
+	MinAge   duration `toml:"min_age"`   // Files younger than this are never deleted
+	MaxAge   duration `toml:"max_age"`   // Files older than this are always deleted
+	MaxSize  byteSize `toml:"max_size"`  // Size budget for the combined target dirs
+	MaxFiles int      `toml:"max_files"` // File count budget for the combined target dirs
+
+	VerifyEvery   *int `toml:"verify_every"`    // Deletions between statfs checks (default 100, 0 disables)
+	CrossMounts   bool `toml:"cross_mounts"`    // Descend into filesystems mounted below the target dirs
+	SkipOpenFiles bool `toml:"skip_open_files"` // Never delete files held open by a process (Linux only)
+	SkipLocked    bool `toml:"skip_locked"`     // Never delete files holding advisory locks (Linux only)
+
+	AgeSource string `toml:"age_source"` // mtime (default), atime, ctime or btime
+
+	// Timestamps embedded in file names, e.g. cam1_2024-05-01T13-00-00.mp4
+	NameTimePattern  string `toml:"name_time_pattern"`  // Regex; the first capture group holds the timestamp
+	NameTimeLayout   string `toml:"name_time_layout"`   // Go time layout, e.g. "2006-01-02T15-04-05"
+	NameTimeFallback string `toml:"name_time_fallback"` // "mtime" (default) or "skip" for names that don't match
+
+	// Fair share: immediate subdirectories of the target dirs are tenants, and
+	// the tenant furthest over its share (by weight, default 1) is cleaned first
+	FairShare     bool               `toml:"fair_share"`
+	TenantWeights map[string]float64 `toml:"tenant_weights"`
+
+	// Never delete the newest keep_latest files of each directory, or of each
+	// group_by match (a regex over the file name) within a directory
+	KeepLatest int    `toml:"keep_latest"`
+	GroupBy    string `toml:"group_by"`
+
+	// Grandfather-father-son retention, e.g. for backup dumps
+	Retention *RetentionPolicy `toml:"retention"`
+
+	// Delete whole directories: with unit = "directory" every directory
+	// unit_depth levels below a target dir (default 1) is removed as one unit,
+	// oldest first by its newest file or, with unit_age = "mtime", its own mtime
+	Unit      string `toml:"unit"`
+	UnitDepth int    `toml:"unit_depth"`
+	UnitAge   string `toml:"unit_age"`
+
+	// Read user.vacuum.expires, user.vacuum.priority and user.vacuum.keep
+	// extended attributes (Linux only; costs a syscall per file)
+	Xattrs bool `toml:"xattrs"`
+
+	// Directories tagged with CACHEDIR.TAG: "only" cleans nothing but their
+	// contents, "prefer" deletes them before anything else
+	CacheDirTag string `toml:"cachedir_tag"`
+
+	// Delete sidecar files (subtitles, thumbnails, indexes) together with
+	// their primary file as one unit
+	Sidecar *SidecarRule `toml:"sidecar"`
+
+	// Ordered tiers of directories; each tier is drained before the next. Tier
+	// dirs are added to target_dirs, and target dirs not in a tier come last.
+	Tiers [][]string `toml:"tiers"`
+
+	Order      string   `toml:"order"`       // Deletion order: oldest (default), largest or score
+	AgeWeight  *float64 `toml:"age_weight"`  // Weight of age in the score order (default 1)
+	SizeWeight *float64 `toml:"size_weight"` // Weight of size in the score order (default 1)
+
+	// Truncate deleted files still held open by a process when free space is low (Linux only)
+	TruncateDeletedOpen bool `toml:"truncate_deleted_open_files"`
+
+	// High/low watermarks: cleanup starts below the trigger and frees space up to the target
+	TriggerFreePercent *float64  `toml:"trigger_free_percent"` // Defaults to min_free_percent
+	TargetFreePercent  *float64  `toml:"target_free_percent"`  // Defaults to the trigger
+	TriggerFreeBytes   *byteSize `toml:"trigger_free_bytes"`   // Defaults to min_free_bytes
+	TargetFreeBytes    *byteSize `toml:"target_free_bytes"`    // Defaults to the trigger
+}
+
+// thresholds define when a cleanup starts and how much free space it restores
+type thresholds struct {
+	TriggerFreePercent float64
+	TriggerFreeBytes   uint64
+	TargetFreePercent  float64
+	TargetFreeBytes    uint64
+
+	MinFreeInodesPercent float64
+	MinFreeInodes        uint64
 }
 
 // duration is a wrapper around time.Duration to support TOML string decoding
@@ -45,6 +131,172 @@ func (d *duration) UnmarshalText(text []byte) error {
 	return err
 }
 
+// resolveThresholds applies global defaults and watermark overrides for a location
+func resolveThresholds(global GlobalConfig, loc LocationConfig) (thresholds, error) {
+	th := thresholds{
+		TriggerFreePercent:   global.MinFreePercent,
+		TriggerFreeBytes:     global.MinFreeBytes.Bytes,
+		MinFreeInodesPercent: global.MinFreeInodesPercent,
+		MinFreeInodes:        global.MinFreeInodes,
+	}
+	if loc.MinFreeInodesPercent != nil {
+		th.MinFreeInodesPercent = *loc.MinFreeInodesPercent
+	}
+	if loc.MinFreeInodes != nil {
+		th.MinFreeInodes = *loc.MinFreeInodes
+	}
+	if loc.MinFreePercent != nil {
+		th.TriggerFreePercent = *loc.MinFreePercent
+	}
+	if loc.MinFreeBytes != nil {
+		th.TriggerFreeBytes = loc.MinFreeBytes.Bytes
+	}
+	if loc.TriggerFreePercent != nil {
+		th.TriggerFreePercent = *loc.TriggerFreePercent
+	}
+	if loc.TriggerFreeBytes != nil {
+		th.TriggerFreeBytes = loc.TriggerFreeBytes.Bytes
+	}
+
+	th.TargetFreePercent = th.TriggerFreePercent
+	if loc.TargetFreePercent != nil {
+		th.TargetFreePercent = *loc.TargetFreePercent
+	}
+	th.TargetFreeBytes = th.TriggerFreeBytes
+	if loc.TargetFreeBytes != nil {
+		th.TargetFreeBytes = loc.TargetFreeBytes.Bytes
+	}
+
+	if th.TargetFreePercent < th.TriggerFreePercent {
+		return th, fmt.Errorf("target_free_percent (%.2f) is below trigger_free_percent (%.2f)", th.TargetFreePercent, th.TriggerFreePercent)
+	}
+	if th.TargetFreeBytes < th.TriggerFreeBytes {
+		return th, fmt.Errorf("target_free_bytes (%s) is below trigger_free_bytes (%s)", formatBytes(th.TargetFreeBytes), formatBytes(th.TriggerFreeBytes))
+	}
+	return th, nil
+}
+
+// locationDirs returns the target dirs of a location, including dirs only listed in tiers
+func locationDirs(loc LocationConfig) []string {
+	dirs := append([]string(nil), loc.TargetDirs...)
+	seen := make(map[string]bool)
+	for _, d := range dirs {
+		seen[filepath.Clean(d)] = true
+	}
+	for _, tier := range loc.Tiers {
+		for _, d := range tier {
+			if !seen[filepath.Clean(d)] {
+				seen[filepath.Clean(d)] = true
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return dirs
+}
+
+// resolveCleanOptions applies global defaults to a location and validates its cleanup settings
+func resolveCleanOptions(global GlobalConfig, loc LocationConfig) (CleanOptions, error) {
+	dryRun := global.DryRun
+	if loc.DryRun != nil {
+		dryRun = *loc.DryRun
+	}
+
+	verifyEvery := defaultVerifyEvery
+	if loc.VerifyEvery != nil {
+		verifyEvery = *loc.VerifyEvery
+	}
+
+	opts := CleanOptions{
+		DryRun:        dryRun,
+		HumanReadable: global.HumanReadable,
+		Include:       loc.Include,
+		Exclude:       loc.Exclude,
+		MinAge:        loc.MinAge.Duration,
+		MaxAge:        loc.MaxAge.Duration,
+		MaxSize:       loc.MaxSize.Bytes,
+		MaxFiles:      loc.MaxFiles,
+		VerifyEvery:   verifyEvery,
+		CrossMounts:   loc.CrossMounts,
+		SkipOpenFiles: loc.SkipOpenFiles,
+		SkipLocked:    loc.SkipLocked,
+		AgeSource:     loc.AgeSource,
+
+		NameTimePattern: loc.NameTimePattern,
+		NameTimeLayout:  loc.NameTimeLayout,
+		NameTimeSkip:    loc.NameTimeFallback == "skip",
+
+		FairShare:     loc.FairShare,
+		TenantWeights: loc.TenantWeights,
+		KeepLatest:    loc.KeepLatest,
+		GroupBy:       loc.GroupBy,
+		Retention:     loc.Retention,
+		Unit:          loc.Unit,
+		UnitDepth:     loc.UnitDepth,
+		UnitAge:       loc.UnitAge,
+		Sidecar:       loc.Sidecar,
+		CacheDirTag:   loc.CacheDirTag,
+		Xattrs:        loc.Xattrs,
+		Tiers:         loc.Tiers,
+		Order:         loc.Order,
+		AgeWeight:     1,
+		SizeWeight:    1,
+
+		TruncateDeletedOpen: loc.TruncateDeletedOpen,
+	}
+
+	if _, err := newPathFilter(opts.Include, opts.Exclude); err != nil {
+		return opts, err
+	}
+	if err := validateAgeSource(opts.AgeSource); err != nil {
+		return opts, err
+	}
+	if _, err := compileNameTimePattern(opts.NameTimePattern, opts.NameTimeLayout); err != nil {
+		return opts, err
+	}
+	if loc.AgeWeight != nil {
+		opts.AgeWeight = *loc.AgeWeight
+	}
+	if loc.SizeWeight != nil {
+		opts.SizeWeight = *loc.SizeWeight
+	}
+	if _, err := compileGroupBy(opts.GroupBy); err != nil {
+		return opts, err
+	}
+	for name, w := range opts.TenantWeights {
+		if w <= 0 {
+			return opts, fmt.Errorf("tenant weight for %q must be positive", name)
+		}
+	}
+	if err := validateOrder(opts.Order); err != nil {
+		return opts, err
+	}
+	if err := validateUnit(opts.Unit, opts.UnitDepth, opts.UnitAge); err != nil {
+		return opts, err
+	}
+	if opts.Unit == UnitDirectory && opts.UnitDepth == 0 {
+		opts.UnitDepth = 1
+	}
+	if err := validateCacheDirTag(opts.CacheDirTag); err != nil {
+		return opts, err
+	}
+	if _, err := compileSidecarRule(opts.Sidecar); err != nil {
+		return opts, err
+	}
+	if opts.Sidecar != nil && opts.Unit == UnitDirectory {
+		return opts, fmt.Errorf("sidecar cannot be combined with unit = \"directory\"")
+	}
+	if err := validateRetention(opts.Retention); err != nil {
+		return opts, err
+	}
+	if opts.AgeWeight < 0 || opts.SizeWeight < 0 {
+		return opts, fmt.Errorf("age_weight and size_weight must not be negative")
+	}
+	if loc.NameTimeFallback != "" && loc.NameTimeFallback != "mtime" && loc.NameTimeFallback != "skip" {
+		return opts, fmt.Errorf("invalid name_time_fallback %q (expected mtime or skip)", loc.NameTimeFallback)
+	}
+	return opts, nil
+}
+
 // LoadConfig loads configuration from the given path (file or directory)
 func LoadConfig(path string) (*Config, error) {
 	config := &Config{
@@ -82,6 +334,12 @@ func LoadConfig(path string) (*Config, error) {
 			if partialConfig.Global.MinFreeBytes.Bytes != 0 {
 				config.Global.MinFreeBytes = partialConfig.Global.MinFreeBytes
 			}
+			if partialConfig.Global.MinFreeInodesPercent != 0 {
+				config.Global.MinFreeInodesPercent = partialConfig.Global.MinFreeInodesPercent
+			}
+			if partialConfig.Global.MinFreeInodes != 0 {
+				config.Global.MinFreeInodes = partialConfig.Global.MinFreeInodes
+			}
 			if partialConfig.Global.DryRun {
 				config.Global.DryRun = true
 			}
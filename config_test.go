@@ -140,3 +140,243 @@ min_free_bytes = "5GB"
 		t.Errorf("Expected Location[0].MinFreeBytes %d, got %d", expectedLocationBytes, config.Locations[0].MinFreeBytes.Bytes)
 	}
 }
+
+func TestLoadConfig_LocationFilters(t *testing.T) {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.toml")
+
+	content := `
+[[location]]
+target_dirs = ["/srv/recordings"]
+include = ["*.mp4", "**/*.log.gz"]
+exclude = ["*.lock", "current/"]
+min_age = "1h"
+max_age = "48h"
+max_size = "500GB"
+max_files = 10000
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	loc := config.Locations[0]
+	if len(loc.Include) != 2 || loc.Include[1] != "**/*.log.gz" {
+		t.Errorf("Unexpected include patterns: %v", loc.Include)
+	}
+	if len(loc.Exclude) != 2 || loc.Exclude[1] != "current/" {
+		t.Errorf("Unexpected exclude patterns: %v", loc.Exclude)
+	}
+	if loc.MinAge.Duration != time.Hour {
+		t.Errorf("Expected MinAge 1h, got %v", loc.MinAge.Duration)
+	}
+	if loc.MaxAge.Duration != 48*time.Hour {
+		t.Errorf("Expected MaxAge 48h, got %v", loc.MaxAge.Duration)
+	}
+	if loc.MaxSize.Bytes != 500*1024*1024*1024 {
+		t.Errorf("Expected MaxSize 500GB, got %d", loc.MaxSize.Bytes)
+	}
+	if loc.MaxFiles != 10000 {
+		t.Errorf("Expected MaxFiles 10000, got %d", loc.MaxFiles)
+	}
+}
+
+func TestResolveThresholds(t *testing.T) {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.toml")
+
+	content := `
+[global]
+min_free_percent = 5.0
+min_free_bytes = "1GB"
+min_free_inodes_percent = 5.0
+
+[[location]]
+target_dirs = ["/tmp"]
+min_free_inodes = 100000
+
+[[location]]
+target_dirs = ["/srv"]
+trigger_free_percent = 10.0
+target_free_percent = 20.0
+target_free_bytes = "50GB"
+
+[[location]]
+target_dirs = ["/var"]
+trigger_free_percent = 30.0
+target_free_percent = 20.0
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	// Without watermarks the trigger and target both come from min_free_*
+	th, err := resolveThresholds(config.Global, config.Locations[0])
+	if err != nil {
+		t.Fatalf("resolveThresholds failed: %v", err)
+	}
+	expected := thresholds{
+		TriggerFreePercent:   5.0,
+		TriggerFreeBytes:     1 << 30,
+		TargetFreePercent:    5.0,
+		TargetFreeBytes:      1 << 30,
+		MinFreeInodesPercent: 5.0,
+		MinFreeInodes:        100000,
+	}
+	if th != expected {
+		t.Errorf("Expected %+v, got %+v", expected, th)
+	}
+
+	th, err = resolveThresholds(config.Global, config.Locations[1])
+	if err != nil {
+		t.Fatalf("resolveThresholds failed: %v", err)
+	}
+	expected = thresholds{
+		TriggerFreePercent:   10.0,
+		TriggerFreeBytes:     1 << 30,
+		TargetFreePercent:    20.0,
+		TargetFreeBytes:      50 << 30,
+		MinFreeInodesPercent: 5.0,
+	}
+	if th != expected {
+		t.Errorf("Expected %+v, got %+v", expected, th)
+	}
+
+	if _, err := resolveThresholds(config.Global, config.Locations[2]); err == nil {
+		t.Errorf("Expected error when target is below trigger")
+	}
+}
+
+func TestResolveCleanOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.toml")
+
+	content := `
+[global]
+dry_run = true
+
+[[location]]
+target_dirs = ["/srv/cameras"]
+dry_run = false
+age_source = "btime"
+name_time_pattern = '_(\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2})'
+name_time_layout = "2006-01-02T15-04-05"
+name_time_fallback = "skip"
+unit = "directory"
+
+[[location]]
+target_dirs = ["/srv/logs"]
+age_source = "birth"
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	opts, err := resolveCleanOptions(config.Global, config.Locations[0])
+	if err != nil {
+		t.Fatalf("resolveCleanOptions failed: %v", err)
+	}
+	if opts.DryRun {
+		t.Errorf("Expected location to override DryRun")
+	}
+	if opts.VerifyEvery != defaultVerifyEvery {
+		t.Errorf("Expected default VerifyEvery %d, got %d", defaultVerifyEvery, opts.VerifyEvery)
+	}
+	if opts.AgeSource != AgeSourceBtime || opts.NameTimeLayout != "2006-01-02T15-04-05" || !opts.NameTimeSkip {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+	if opts.Unit != UnitDirectory || opts.UnitDepth != 1 {
+		t.Errorf("Expected directory units at default depth 1, got %q at %d", opts.Unit, opts.UnitDepth)
+	}
+
+	if _, err := resolveCleanOptions(config.Global, config.Locations[1]); err == nil {
+		t.Errorf("Expected error for invalid age_source")
+	}
+}
+
+func TestResolveCleanOptions_Retention(t *testing.T) {
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.toml")
+
+	content := `
+[[location]]
+target_dirs = ["/srv/backups"]
+[location.retention]
+daily = 7
+monthly = 12
+
+[[location]]
+target_dirs = ["/srv/dumps"]
+[location.retention]
+always = true
+
+[[location]]
+target_dirs = ["/srv/archive"]
+[location.retention]
+daily = 7
+weekly = -1
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	opts, err := resolveCleanOptions(config.Global, config.Locations[0])
+	if err != nil {
+		t.Fatalf("resolveCleanOptions failed: %v", err)
+	}
+	if opts.Retention == nil || opts.Retention.Daily != 7 || opts.Retention.Monthly != 12 {
+		t.Errorf("Unexpected retention: %+v", opts.Retention)
+	}
+
+	if _, err := resolveCleanOptions(config.Global, config.Locations[1]); err == nil {
+		t.Errorf("Expected error for a retention block that keeps nothing")
+	}
+	if _, err := resolveCleanOptions(config.Global, config.Locations[2]); err == nil {
+		t.Errorf("Expected error for a negative retention count")
+	}
+}
+
+func TestLocationDirs(t *testing.T) {
+	loc := LocationConfig{
+		TargetDirs: []string{"/data/recordings", "/data/exports"},
+		Tiers:      [][]string{{"/data/cache", "/data/tmp/"}, {"/data/recordings"}},
+	}
+
+	dirs := locationDirs(loc)
+	expected := []string{"/data/recordings", "/data/exports", "/data/cache", "/data/tmp/"}
+	if len(dirs) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, dirs)
+	}
+	for i := range expected {
+		if dirs[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, dirs)
+			break
+		}
+	}
+
+	if tier := tierOf("/data/tmp", loc.Tiers); tier != 0 {
+		t.Errorf("Expected /data/tmp in tier 0, got %d", tier)
+	}
+	if tier := tierOf("/data/exports", loc.Tiers); tier != 2 {
+		t.Errorf("Expected unlisted /data/exports in tier 2, got %d", tier)
+	}
+}
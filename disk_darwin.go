@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"syscall"
 )
 
@@ -20,9 +21,11 @@ func GetDiskUsage(path string) (DiskUsage, error) {
 	used := total - free
 
 	return DiskUsage{
-		Total: total,
-		Free:  free,
-		Used:  used,
+		Total:       total,
+		Free:        free,
+		Used:        used,
+		TotalInodes: uint64(stat.Files),
+		FreeInodes:  uint64(stat.Ffree),
 	}, nil
 }
 
@@ -49,3 +52,34 @@ func SameFilesystem(paths []string) error {
 	}
 	return nil
 }
+
+// statFile returns the device, inode, link count, allocated blocks and
+// access/change times of a file.
+func statFile(info os.FileInfo) (fileStat, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}, false
+	}
+	return fileStat{
+		Dev:    uint64(stat.Dev),
+		Ino:    uint64(stat.Ino),
+		Nlink:  uint64(stat.Nlink),
+		Blocks: int64(stat.Blocks),
+		Atime:  stat.Atimespec.Sec,
+		Ctime:  stat.Ctimespec.Sec,
+	}, true
+}
+
+// birthTime returns the creation time of the file at path.
+func birthTime(path string) (int64, bool) {
+	var stat syscall.Stat_t
+	if err := syscall.Lstat(path, &stat); err != nil {
+		return 0, false
+	}
+	return stat.Birthtimespec.Sec, true
+}
+
+// mountOptions is not implemented on macOS.
+func mountOptions(path string) (string, bool) {
+	return "", false
+}
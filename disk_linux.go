@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 // GetDiskUsage returns the disk usage for the filesystem containing the given path.
@@ -20,9 +27,11 @@ func GetDiskUsage(path string) (DiskUsage, error) {
 	used := total - free
 
 	return DiskUsage{
-		Total: total,
-		Free:  free,
-		Used:  used,
+		Total:       total,
+		Free:        free,
+		Used:        used,
+		TotalInodes: uint64(stat.Files),
+		FreeInodes:  uint64(stat.Ffree),
 	}, nil
 }
 
@@ -49,3 +58,93 @@ func SameFilesystem(paths []string) error {
 	}
 	return nil
 }
+
+// statFile returns the device, inode, link count, allocated blocks and
+// access/change times of a file.
+func statFile(info os.FileInfo) (fileStat, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}, false
+	}
+	return fileStat{
+		Dev:    uint64(stat.Dev),
+		Ino:    uint64(stat.Ino),
+		Nlink:  uint64(stat.Nlink),
+		Blocks: int64(stat.Blocks),
+		Atime:  int64(stat.Atim.Sec),
+		Ctime:  int64(stat.Ctim.Sec),
+	}, true
+}
+
+// birthTime returns the creation time of the file at path using statx. It
+// reports false when the kernel or filesystem doesn't record birth times.
+func birthTime(path string) (int64, bool) {
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx); err != nil {
+		return 0, false
+	}
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		return 0, false
+	}
+	return stx.Btime.Sec, true
+}
+
+// mountOptions returns the mount options of the filesystem containing path,
+// read from /proc/self/mountinfo.
+func mountOptions(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	// The longest mount point containing path is the one it lives on
+	var best, options string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[4])
+		if !pathWithin(abs, mountPoint) || len(mountPoint) < len(best) {
+			continue
+		}
+		best, options = mountPoint, fields[5]
+	}
+	return options, best != ""
+}
+
+// unescapeMountPath decodes the octal escapes (e.g. "\040" for a space) used in mountinfo
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func pathWithin(path, dir string) bool {
+	if dir == "/" || path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestBirthTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.ts")
+	if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx); err != nil {
+		t.Skipf("statx not supported: %v", err)
+	}
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		t.Skip("filesystem doesn't record birth times")
+	}
+
+	btime, ok := birthTime(path)
+	if !ok || btime != stx.Btime.Sec {
+		t.Fatalf("birthTime = %d, %v; want %d, true", btime, ok, stx.Btime.Sec)
+	}
+	if d := time.Since(time.Unix(btime, 0)); d < -time.Minute || d > time.Minute {
+		t.Errorf("Birth time %v is not close to now", time.Unix(btime, 0))
+	}
+
+	// An old mtime doesn't change the age taken from btime
+	old := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if age, ok := fileAge(path, info, AgeSourceBtime); !ok || age != btime {
+		t.Errorf("fileAge = %d, %v; want %d, true", age, ok, btime)
+	}
+}
+
+func TestBirthTime_Fallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gone.ts")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a birth time the age falls back to mtime and is counted
+	if _, ok := birthTime(path); ok {
+		t.Error("birthTime should fail for a missing file")
+	}
+	if age, ok := fileAge(path, info, AgeSourceBtime); ok || age != old.Unix() {
+		t.Errorf("fileAge = %d, %v; want %d, false", age, ok, old.Unix())
+	}
+}
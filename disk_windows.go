@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"syscall"
 	"unsafe"
@@ -64,3 +65,26 @@ func SameFilesystem(paths []string) error {
 	}
 	return nil
 }
+
+// statFile is not supported on Windows; callers fall back to the apparent file size.
+func statFile(info os.FileInfo) (fileStat, bool) {
+	return fileStat{}, false
+}
+
+// birthTime returns the creation time of the file at path.
+func birthTime(path string) (int64, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, false
+	}
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return 0, false
+	}
+	return data.CreationTime.Nanoseconds() / 1e9, true
+}
+
+// mountOptions is not supported on Windows.
+func mountOptions(path string) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pathFilter decides which paths under a target directory CleanUp may touch.
+// Patterns are matched against the slash-separated path relative to the target
+// directory. A pattern without a slash matches the base name at any depth, a
+// leading or inner slash anchors it to the target directory, "**" matches any
+// number of path segments and a trailing slash restricts a pattern to
+// directories.
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+// newPathFilter validates the include and exclude patterns of a location
+func newPathFilter(include, exclude []string) (*pathFilter, error) {
+	for _, patterns := range [][]string{include, exclude} {
+		for _, p := range patterns {
+			if err := validatePattern(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &pathFilter{include: include, exclude: exclude}, nil
+}
+
+// Excluded reports whether rel (a path relative to the target directory) matches
+// an exclude pattern. Excluded directories are skipped entirely.
+func (f *pathFilter) Excluded(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range f.exclude {
+		if matchPattern(p, rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Included reports whether the file at rel is a cleanup candidate. With no
+// include patterns every file is included.
+func (f *pathFilter) Included(rel string) bool {
+	if f == nil || len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if matchPattern(p, rel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// relPath returns path relative to root in slash-separated form
+func relPath(root, p string) string {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func validatePattern(pattern string) error {
+	if strings.TrimSuffix(pattern, "/") == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	for _, seg := range strings.Split(strings.TrimSuffix(pattern, "/"), "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchPattern matches a single include/exclude pattern against rel
+func matchPattern(pattern, rel string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	// Any slash left, including a leading one, anchors the pattern to the
+	// target directory
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where a "**"
+// segment matches zero or more path segments.
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**" and try every possible split
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
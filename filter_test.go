@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		rel      string
+		isDir    bool
+		expected bool
+	}{
+		{"*.lock", "app.lock", false, true},
+		{"*.lock", "sub/dir/app.lock", false, true},
+		{"*.lock", "app.log", false, false},
+		{"logs/*.gz", "logs/a.gz", false, true},
+		{"logs/*.gz", "logs/old/a.gz", false, false},
+		{"logs/**/*.gz", "logs/a.gz", false, true},
+		{"logs/**/*.gz", "logs/old/2024/a.gz", false, true},
+		{"**/*.mp4", "cam1/day/clip.mp4", false, true},
+		{"current/", "current", true, true},
+		{"current/", "current", false, false},
+		{"current/**", "current", true, true},
+		{"current/**", "current/segment.ts", false, true},
+		{"/top.idx", "top.idx", false, true},
+		{"/top.idx", "sub/top.idx", false, false},
+		{"/current/", "current", true, true},
+		{"/current/", "data/current", true, false},
+	}
+
+	for _, test := range tests {
+		result := matchPattern(test.pattern, test.rel, test.isDir)
+		if result != test.expected {
+			t.Errorf("matchPattern(%q, %q, %v) = %v; want %v", test.pattern, test.rel, test.isDir, result, test.expected)
+		}
+	}
+}
+
+func TestNewPathFilter_InvalidPattern(t *testing.T) {
+	if _, err := newPathFilter([]string{"[a-"}, nil); err == nil {
+		t.Errorf("Expected error for invalid include pattern")
+	}
+	if _, err := newPathFilter(nil, []string{""}); err == nil {
+		t.Errorf("Expected error for empty exclude pattern")
+	}
+}
@@ -3,3 +3,5 @@ module akayami.com/partition-vacuum
 go 1.25.4
 
 require github.com/BurntSushi/toml v1.5.0
+
+require golang.org/x/sys v0.40.0
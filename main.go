@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -21,6 +23,7 @@ func main() {
 
 	human := flag.Bool("h", false, "Show output in human-readable format")
 	v := flag.Bool("v", false, "Print version and exit")
+	listPresets := flag.Bool("presets", false, "List the built-in location presets and exit")
 
 	configPath := flag.String("config", "", "Path to configuration file")
 	flag.Parse()
@@ -30,6 +33,11 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *listPresets {
+		printPresets(os.Stdout, userHomes())
+		os.Exit(0)
+	}
+
 	// Check if we should run in config mode
 	useConfig := *configPath != ""
 	if !useConfig {
@@ -89,11 +97,19 @@ func runLegacyMode(partition, targetDir string, minFreePercent float64, minFreeB
 	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
+	th := thresholds{
+		TriggerFreePercent: minFreePercent,
+		TriggerFreeBytes:   minFreeBytes,
+		TargetFreePercent:  minFreePercent,
+		TargetFreeBytes:    minFreeBytes,
+	}
+	opts := CleanOptions{DryRun: dryRun, HumanReadable: humanReadable, VerifyEvery: defaultVerifyEvery}
+
 	// Run once immediately
-	checkAndClean([]string{targetDir}, minFreePercent, minFreeBytes, dryRun, humanReadable)
+	checkAndClean([]string{targetDir}, th, opts)
 
 	for range ticker.C {
-		checkAndClean([]string{targetDir}, minFreePercent, minFreeBytes, dryRun, humanReadable)
+		checkAndClean([]string{targetDir}, th, opts)
 	}
 }
 
@@ -120,30 +136,35 @@ func runConfigMode(path string) {
 	// Count active locations
 	activeLocations := 0
 
+	// Presets may expand into one location per filesystem. Expanded locations
+	// keep the index of their [[location]] for log messages.
+	type indexedLocation struct {
+		index int
+		loc   LocationConfig
+	}
+	var locations []indexedLocation
+	homes := userHomes()
 	for i, loc := range config.Locations {
-		// Apply defaults if not set
-		minFree := config.Global.MinFreePercent
-		if loc.MinFreePercent != nil {
-			minFree = *loc.MinFreePercent
+		expanded, err := expandPreset(loc, homes)
+		if err != nil {
+			log.Printf("Location %d configuration error: %v", i, err)
+			continue
 		}
-
-		minFreeBytes := config.Global.MinFreeBytes.Bytes
-		if loc.MinFreeBytes != nil {
-			minFreeBytes = loc.MinFreeBytes.Bytes
+		for _, l := range expanded {
+			locations = append(locations, indexedLocation{i, l})
 		}
+	}
 
+	for _, il := range locations {
+		i, loc := il.index, il.loc
+		loc.TargetDirs = locationDirs(loc)
+
+		// Apply defaults if not set
 		interval := config.Global.CheckInterval.Duration
 		if loc.CheckInterval != nil {
 			interval = loc.CheckInterval.Duration
 		}
 
-		dryRun := config.Global.DryRun
-		if loc.DryRun != nil {
-			dryRun = *loc.DryRun
-		}
-
-		humanReadable := config.Global.HumanReadable
-
 		if len(loc.TargetDirs) == 0 {
 			log.Printf("Location %d has no target directories, skipping", i)
 			continue
@@ -154,20 +175,36 @@ func runConfigMode(path string) {
 			continue
 		}
 
+		th, err := resolveThresholds(config.Global, loc)
+		if err != nil {
+			log.Printf("Location %d configuration error: %v", i, err)
+			continue
+		}
+
+		opts, err := resolveCleanOptions(config.Global, loc)
+		if err != nil {
+			log.Printf("Location %d configuration error: %v", i, err)
+			continue
+		}
+
+		if opts.AgeSource == AgeSourceAtime {
+			warnNoAtime(loc.TargetDirs)
+		}
+
 		log.Printf("Starting monitor for directories: %v", loc.TargetDirs)
 		activeLocations++
 
-		go func(idx int, l LocationConfig, mf float64, mfb uint64, iv time.Duration, dr, hr bool) {
+		go func(idx int, l LocationConfig, t thresholds, iv time.Duration, o CleanOptions) {
 			ticker := time.NewTicker(iv)
 			defer ticker.Stop()
 
 			// Run once immediately
-			checkAndClean(l.TargetDirs, mf, mfb, dr, hr)
+			checkAndClean(l.TargetDirs, t, o)
 
 			for range ticker.C {
-				checkAndClean(l.TargetDirs, mf, mfb, dr, hr)
+				checkAndClean(l.TargetDirs, t, o)
 			}
-		}(i, loc, minFree, minFreeBytes, interval, dryRun, humanReadable)
+		}(i, loc, th, interval, opts)
 	}
 
 	if activeLocations == 0 {
@@ -178,7 +215,23 @@ func runConfigMode(path string) {
 	<-done
 }
 
-func checkAndClean(targetDirs []string, minFreePercent float64, minFreeBytes uint64, dryRun, humanReadable bool) {
+// warnNoAtime warns when access times are requested on a filesystem that doesn't record them
+func warnNoAtime(dirs []string) {
+	for _, dir := range dirs {
+		options, ok := mountOptions(dir)
+		if !ok {
+			continue
+		}
+		for _, opt := range strings.Split(options, ",") {
+			if opt == "noatime" {
+				log.Printf("Warning: age_source is atime but %s is mounted with noatime; access times are not updated", dir)
+				break
+			}
+		}
+	}
+}
+
+func checkAndClean(targetDirs []string, th thresholds, opts CleanOptions) {
 	if len(targetDirs) == 0 {
 		return
 	}
@@ -194,46 +247,139 @@ func checkAndClean(targetDirs []string, minFreePercent float64, minFreeBytes uin
 
 	freePercent := (float64(usage.Free) / float64(usage.Total)) * 100
 
-	if humanReadable {
+	if opts.HumanReadable {
 		log.Printf("[%s] Disk Usage: Total=%s, Free=%s (%.2f%%), Used=%s",
 			partition, formatBytes(usage.Total), formatBytes(usage.Free), freePercent, formatBytes(usage.Used))
 	} else {
 		log.Printf("[%s] Disk Usage: Total=%d, Free=%d (%.2f%%), Used=%d", partition, usage.Total, usage.Free, freePercent, usage.Used)
 	}
 
+	// Space held by deleted files that are still open is invisible to CleanUp.
+	// Report it, and release it if allowed and free space is low.
+	breached := (th.TriggerFreePercent > 0 && freePercent < th.TriggerFreePercent) ||
+		(th.TriggerFreeBytes > 0 && usage.Free < th.TriggerFreeBytes)
+	if reclaimDeletedOpenFiles(partition, breached && opts.TruncateDeletedOpen, opts) > 0 {
+		if refreshed, err := GetDiskUsage(partition); err == nil {
+			usage = refreshed
+			freePercent = (float64(usage.Free) / float64(usage.Total)) * 100
+			log.Printf("[%s] Free space after truncating deleted files: %s (%.2f%%)", partition, sizeString(usage.Free, opts.HumanReadable), freePercent)
+		}
+	}
+
 	// Calculate target free bytes from percentage
-	targetFreeByPercent := uint64(float64(usage.Total) * (minFreePercent / 100))
+	targetFreeByPercent := uint64(float64(usage.Total) * (th.TargetFreePercent / 100))
 
-	// Use the larger of percentage-based or absolute minimum
+	// Use the larger of percentage-based or absolute target
 	targetFreeBytes := targetFreeByPercent
-	if minFreeBytes > targetFreeBytes {
-		targetFreeBytes = minFreeBytes
+	if th.TargetFreeBytes > targetFreeBytes {
+		targetFreeBytes = th.TargetFreeBytes
 	}
 
 	// Check if we need to clean up
-	needsCleanup := false
-	if minFreePercent > 0 && freePercent < minFreePercent {
-		needsCleanup = true
+	spaceLow := false
+	if th.TriggerFreePercent > 0 && freePercent < th.TriggerFreePercent {
+		spaceLow = true
+	}
+	if th.TriggerFreeBytes > 0 && usage.Free < th.TriggerFreeBytes {
+		spaceLow = true
 	}
-	if minFreeBytes > 0 && usage.Free < minFreeBytes {
-		needsCleanup = true
+	needsCleanup := spaceLow
+
+	// Inode thresholds only apply where the filesystem reports inodes
+	if usage.TotalInodes > 0 && (th.MinFreeInodesPercent > 0 || th.MinFreeInodes > 0) {
+		freeInodesPercent := (float64(usage.FreeInodes) / float64(usage.TotalInodes)) * 100
+		log.Printf("[%s] Inode Usage: Total=%d, Free=%d (%.2f%%)", partition, usage.TotalInodes, usage.FreeInodes, freeInodesPercent)
+
+		targetFreeInodes := uint64(float64(usage.TotalInodes) * (th.MinFreeInodesPercent / 100))
+		if th.MinFreeInodes > targetFreeInodes {
+			targetFreeInodes = th.MinFreeInodes
+		}
+		if usage.FreeInodes < targetFreeInodes {
+			log.Printf("[%s] Free inodes (%.2f%% / %d) are below minimum (%.2f%% / %d). Initiating cleanup...",
+				partition, freeInodesPercent, usage.FreeInodes, th.MinFreeInodesPercent, th.MinFreeInodes)
+			needsCleanup = true
+		}
+		opts.TargetFreeInodes = targetFreeInodes
+		opts.CurrentFreeInodes = usage.FreeInodes
 	}
 
 	if needsCleanup {
-		if minFreeBytes > 0 {
+		if spaceLow && th.TriggerFreeBytes > 0 {
 			log.Printf("[%s] Free space (%.2f%% / %s) is below minimum (%.2f%% / %s). Initiating cleanup...",
-				partition, freePercent, formatBytes(usage.Free), minFreePercent, formatBytes(minFreeBytes))
-		} else {
-			log.Printf("[%s] Free space (%.2f%%) is below minimum (%.2f%%). Initiating cleanup...", partition, freePercent, minFreePercent)
+				partition, freePercent, formatBytes(usage.Free), th.TriggerFreePercent, formatBytes(th.TriggerFreeBytes))
+		} else if spaceLow {
+			log.Printf("[%s] Free space (%.2f%%) is below minimum (%.2f%%). Initiating cleanup...", partition, freePercent, th.TriggerFreePercent)
 		}
-
-		err := CleanUp(targetDirs, targetFreeBytes, usage.Free, dryRun, humanReadable)
-		if err != nil {
-			log.Printf("[%s] Error during cleanup: %v", partition, err)
-		} else {
-			log.Printf("[%s] Cleanup completed successfully.", partition)
+		if th.TargetFreePercent != th.TriggerFreePercent || th.TargetFreeBytes != th.TriggerFreeBytes {
+			log.Printf("[%s] Cleaning up to target free space of %s", partition, formatBytes(targetFreeBytes))
 		}
 	} else {
 		log.Printf("[%s] Free space is sufficient.", partition)
+		if !opts.runsEveryCheck() {
+			return
+		}
+		// Only enforce retention and size budgets; no space needs to be reclaimed
+		log.Printf("[%s] Enforcing retention and size limits...", partition)
+		targetFreeBytes = usage.Free
+	}
+
+	err = CleanUp(targetDirs, targetFreeBytes, usage.Free, opts)
+	if err != nil {
+		log.Printf("[%s] Error during cleanup: %v", partition, err)
+	} else {
+		log.Printf("[%s] Cleanup completed successfully.", partition)
+	}
+}
+
+// reclaimDeletedOpenFiles logs deleted files on the partition's filesystem that
+// are still held open, grouped by process. With truncate set it truncates them
+// through /proc and returns the number of bytes released.
+func reclaimDeletedOpenFiles(partition string, truncate bool, opts CleanOptions) uint64 {
+	dev, ok := deviceOf(partition)
+	if !ok {
+		return 0
+	}
+	deleted, err := deletedOpenFiles(dev)
+	if err != nil || len(deleted) == 0 {
+		return 0
+	}
+
+	heldByPID := make(map[int]uint64)
+	var pids []int
+	var total uint64
+	for _, f := range deleted {
+		if _, seen := heldByPID[f.PID]; !seen {
+			pids = append(pids, f.PID)
+		}
+		heldByPID[f.PID] += f.Size
+		total += f.Size
+	}
+	sort.Ints(pids)
+
+	log.Printf("[%s] Deleted files still held open: %s", partition, sizeString(total, opts.HumanReadable))
+	for _, pid := range pids {
+		log.Printf("[%s]   PID %d holds %s in deleted files", partition, pid, sizeString(heldByPID[pid], opts.HumanReadable))
+	}
+
+	if !truncate {
+		return 0
+	}
+
+	var released uint64
+	for _, f := range deleted {
+		if f.Size == 0 {
+			continue // Empty, or already counted through another descriptor
+		}
+		if opts.DryRun {
+			log.Printf("[%s] [DRY RUN] Would truncate deleted file %s held by PID %d via %s", partition, f.Name, f.PID, f.Path)
+			continue
+		}
+		if err := truncateDeletedFile(f); err != nil {
+			log.Printf("[%s] Failed to truncate deleted file %s held by PID %d: %v", partition, f.Name, f.PID, err)
+			continue
+		}
+		log.Printf("[%s] Truncated deleted file %s held by PID %d (size: %s)", partition, f.Name, f.PID, sizeString(f.Size, opts.HumanReadable))
+		released += f.Size
 	}
+	return released
 }
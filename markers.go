@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Marker files application owners can drop into a target tree
+const (
+	ignoreFileName = ".vacuumignore" // gitignore-style patterns of paths to keep, scoped to its directory
+	keepFileName   = ".vacuumkeep"   // Protects the directory and everything below it
+)
+
+// ignoreRule is one line of a .vacuumignore file
+type ignoreRule struct {
+	pattern  string // Without leading and trailing slashes
+	negate   bool   // Re-include paths matched by earlier rules
+	anchored bool   // Match the path relative to the file's directory, not just the base name
+	dirOnly  bool   // Match directories only
+}
+
+// match reports whether the rule matches rel, a path relative to the
+// directory holding the .vacuumignore file
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// markerTree collects the .vacuumignore rules of the directories seen while
+// walking a target dir. Directories must be entered before their contents,
+// which filepath.WalkDir guarantees.
+type markerTree struct {
+	root  string
+	rules map[string][]ignoreRule
+}
+
+func newMarkerTree(root string) *markerTree {
+	return &markerTree{root: filepath.Clean(root), rules: make(map[string][]ignoreRule)}
+}
+
+// isMarkerFile reports whether name is one of the marker files, which are
+// never deleted themselves
+func isMarkerFile(name string) bool {
+	return name == ignoreFileName || name == keepFileName
+}
+
+// enter loads the .vacuumignore file of dir, if any, and reports whether dir
+// holds a .vacuumkeep file protecting its whole subtree.
+func (t *markerTree) enter(dir string) bool {
+	if _, err := os.Lstat(filepath.Join(dir, keepFileName)); err == nil {
+		return true
+	}
+	rules, err := readIgnoreFile(filepath.Join(dir, ignoreFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Unable to read %s: %v\n", filepath.Join(dir, ignoreFileName), err)
+		}
+		return false
+	}
+	if len(rules) > 0 {
+		t.rules[filepath.Clean(dir)] = rules
+	}
+	return false
+}
+
+// Ignored reports whether the .vacuumignore files of the directories above
+// path protect it. As with gitignore, the last matching rule wins and rules
+// in deeper directories take precedence.
+func (t *markerTree) Ignored(path string, isDir bool) bool {
+	if t == nil || len(t.rules) == 0 {
+		return false
+	}
+	segs := strings.Split(relPath(t.root, path), "/")
+	ignored := false
+	dir := t.root
+	for i := range segs {
+		if i > 0 {
+			dir = filepath.Join(dir, segs[i-1])
+		}
+		rel := strings.Join(segs[i:], "/")
+		for _, r := range t.rules[dir] {
+			if r.match(rel, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// readIgnoreFile parses a .vacuumignore file. Blank lines and lines starting
+// with # are skipped, a leading ! re-includes paths, and a pattern containing
+// a slash other than a trailing one is anchored to the file's directory.
+func readIgnoreFile(name string) ([]ignoreRule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if err := validatePattern(line); err != nil {
+			fmt.Printf("Ignoring line in %s: %v\n", name, err)
+			continue
+		}
+		r.dirOnly = strings.HasSuffix(line, "/")
+		line = strings.TrimSuffix(line, "/")
+		r.anchored = strings.Contains(line, "/")
+		r.pattern = strings.TrimPrefix(line, "/")
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanUp_Markers(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"other.log":              "",
+		"keep/old.log":           "",
+		"keep/.vacuumkeep":       "",
+		"emptykeep/.vacuumkeep":  "",
+		"logs/.vacuumignore":     "# Index files and the live directory\n*.idx\ncurrent/\n",
+		"logs/a.idx":             "",
+		"logs/a.log":             "",
+		"logs/current/x.log":     "",
+		"logs/sub/b.idx":         "",
+		"logs/sub/c.idx":         "",
+		"logs/sub/.vacuumignore": "!b.idx\n",
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	for name, content := range files {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if content == "" {
+			content = "0123456789"
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Ask for more space than there is, so every eligible file goes
+	CleanUp([]string{tempDir}, 1<<30, 0, CleanOptions{})
+
+	for _, name := range []string{"other.log", "logs/a.log", "logs/sub/b.idx"} {
+		if _, err := os.Stat(filepath.Join(tempDir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+	for _, name := range []string{
+		"keep/old.log", "keep/.vacuumkeep", "emptykeep/.vacuumkeep", "logs/.vacuumignore",
+		"logs/a.idx", "logs/current/x.log", "logs/sub/c.idx", "logs/sub/.vacuumignore",
+	} {
+		if _, err := os.Stat(filepath.Join(tempDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ignoreFileName)
+	content := "/build\ndocs/*.md\ntmp/\n\\#notes\n\n# comment\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := readIgnoreFile(path)
+	if err != nil {
+		t.Fatalf("readIgnoreFile failed: %v", err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %+v", rules)
+	}
+
+	tests := []struct {
+		rule  int
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{0, "build", true, true},
+		{0, "src/build", true, false}, // Anchored to the file's directory
+		{1, "docs/readme.md", false, true},
+		{1, "src/docs/readme.md", false, false},
+		{2, "src/tmp", true, true}, // A trailing slash alone doesn't anchor
+		{2, "tmp", false, false},   // Directories only
+		{3, "#notes", false, true},
+	}
+	for _, tt := range tests {
+		if got := rules[tt.rule].match(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("rule %q match(%q, %v) = %v; want %v", rules[tt.rule].pattern, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
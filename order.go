@@ -0,0 +1,214 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Supported values of CleanOptions.Order
+const (
+	OrderOldest  = "oldest"
+	OrderLargest = "largest"
+	OrderScore   = "score"
+)
+
+// validateOrder checks an order setting
+func validateOrder(order string) error {
+	switch order {
+	case "", OrderOldest, OrderLargest, OrderScore:
+		return nil
+	}
+	return fmt.Errorf("invalid order %q (expected oldest, largest or score)", order)
+}
+
+// sortCandidates orders files so that the ones to delete first come first.
+// tenantBytes holds the space used by each tenant for the fair share mode.
+func sortCandidates(files []FileInfo, opts CleanOptions, tenantBytes map[string]uint64) {
+	switch opts.Order {
+	case OrderLargest:
+		// Largest reclaimable space first, oldest first among equals
+		sort.SliceStable(files, func(i, j int) bool {
+			if files[i].Reclaim != files[j].Reclaim {
+				return files[i].Reclaim > files[j].Reclaim
+			}
+			return files[i].Age < files[j].Age
+		})
+	case OrderScore:
+		scores := scoreFiles(files, opts.AgeWeight, opts.SizeWeight)
+		idx := make([]int, len(files))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(a, b int) bool {
+			return scores[idx[a]] > scores[idx[b]]
+		})
+		sorted := make([]FileInfo, len(files))
+		for i, k := range idx {
+			sorted[i] = files[k]
+		}
+		copy(files, sorted)
+	default:
+		// Sort by Age ascending (oldest first)
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Age < files[j].Age
+		})
+	}
+
+	// Producers can move their files forward or back with user.vacuum.priority
+	if opts.Xattrs {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Priority > files[j].Priority
+		})
+	}
+
+	if opts.FairShare {
+		fairShareOrder(files, tenantBytes, opts.TenantWeights)
+	}
+
+	// Drain tiers in order, keeping the order above within each tier
+	if len(opts.Tiers) > 0 {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Tier < files[j].Tier
+		})
+	}
+
+	// Tagged cache contents go before anything else
+	if opts.CacheDirTag == CacheDirTagPrefer {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Cache && !files[j].Cache
+		})
+	}
+}
+
+// tierOf returns the priority tier of a target dir. Dirs not listed in any
+// tier come after all listed tiers.
+func tierOf(dir string, tiers [][]string) int {
+	dir = filepath.Clean(dir)
+	for i, tier := range tiers {
+		for _, d := range tier {
+			if filepath.Clean(d) == dir {
+				return i
+			}
+		}
+	}
+	return len(tiers)
+}
+
+// scoreFiles weighs each file's age and reclaimable size, both normalized to
+// the largest value among the candidates, so that big old files score highest.
+// With no weights set, age and size count equally.
+func scoreFiles(files []FileInfo, ageWeight, sizeWeight float64) []float64 {
+	if ageWeight == 0 && sizeWeight == 0 {
+		ageWeight, sizeWeight = 1, 1
+	}
+
+	now := time.Now().Unix()
+	var maxAge, maxSize float64
+	for _, f := range files {
+		if age := float64(now - f.Age); age > maxAge {
+			maxAge = age
+		}
+		if size := float64(f.Reclaim); size > maxSize {
+			maxSize = size
+		}
+	}
+
+	scores := make([]float64, len(files))
+	for i, f := range files {
+		var score float64
+		if maxAge > 0 {
+			score += ageWeight * float64(now-f.Age) / maxAge
+		}
+		if maxSize > 0 {
+			score += sizeWeight * float64(f.Reclaim) / maxSize
+		}
+		scores[i] = score
+	}
+	return scores
+}
+
+// sortIndexesByAge sorts indexes into files by age, oldest first
+func sortIndexesByAge(idx []int, files []FileInfo) {
+	sort.SliceStable(idx, func(a, b int) bool {
+		return files[idx[a]].Age < files[idx[b]].Age
+	})
+}
+
+// tenantOf returns the tenant a file belongs to in fair share mode: the
+// immediate subdirectory of the target dir it was found in. Files directly in
+// the target dir form a tenant of their own.
+func tenantOf(dir, path string) string {
+	rel := relPath(dir, path)
+	if i := strings.Index(rel, "/"); i >= 0 {
+		return filepath.Join(dir, rel[:i])
+	}
+	return filepath.Clean(dir)
+}
+
+// fairShareOrder reorders files so that each deletion comes from the tenant
+// using the most space relative to its weight, keeping the existing order
+// within each tenant. Tenant weights are looked up by subdirectory name and
+// default to 1.
+func fairShareOrder(files []FileInfo, tenantBytes map[string]uint64, weights map[string]float64) {
+	queues := make(map[string][]FileInfo)
+	for _, f := range files {
+		queues[f.Tenant] = append(queues[f.Tenant], f)
+	}
+
+	usage := make(map[string]float64)
+	h := &tenantHeap{}
+	for tenant := range queues {
+		usage[tenant] = float64(tenantBytes[tenant])
+		weight := 1.0
+		if w, ok := weights[filepath.Base(tenant)]; ok && w > 0 {
+			weight = w
+		}
+		heap.Push(h, &tenantShare{name: tenant, weight: weight, load: usage[tenant] / weight})
+	}
+
+	// Simulate the deletions: take the next file from the most loaded tenant,
+	// then update its load
+	i := 0
+	for h.Len() > 0 {
+		t := heap.Pop(h).(*tenantShare)
+		f := queues[t.name][0]
+		queues[t.name] = queues[t.name][1:]
+		files[i] = f
+		i++
+
+		if len(queues[t.name]) > 0 {
+			usage[t.name] -= float64(f.Size)
+			t.load = usage[t.name] / t.weight
+			heap.Push(h, t)
+		}
+	}
+}
+
+type tenantShare struct {
+	name   string
+	weight float64
+	load   float64 // Space used per unit of weight
+}
+
+// tenantHeap is a max-heap of tenants by load
+type tenantHeap []*tenantShare
+
+func (h tenantHeap) Len() int { return len(h) }
+func (h tenantHeap) Less(i, j int) bool {
+	if h[i].load != h[j].load {
+		return h[i].load > h[j].load
+	}
+	return h[i].name < h[j].name
+}
+func (h tenantHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *tenantHeap) Push(x any)   { *h = append(*h, x.(*tenantShare)) }
+func (h *tenantHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func paths(files []FileInfo) []string {
+	var result []string
+	for _, f := range files {
+		result = append(result, f.Path)
+	}
+	return result
+}
+
+func TestSortCandidates(t *testing.T) {
+	now := time.Now()
+	newFiles := func() []FileInfo {
+		return []FileInfo{
+			{Path: "old-small", Reclaim: 10, Age: now.Add(-100 * time.Hour).Unix()},
+			{Path: "new-huge", Reclaim: 10000, Age: now.Add(-1 * time.Hour).Unix()},
+			{Path: "mid-big", Reclaim: 8000, Age: now.Add(-80 * time.Hour).Unix()},
+		}
+	}
+
+	tests := []struct {
+		opts     CleanOptions
+		expected []string
+	}{
+		{CleanOptions{}, []string{"old-small", "mid-big", "new-huge"}},
+		{CleanOptions{Order: OrderOldest}, []string{"old-small", "mid-big", "new-huge"}},
+		{CleanOptions{Order: OrderLargest}, []string{"new-huge", "mid-big", "old-small"}},
+		{CleanOptions{Order: OrderScore}, []string{"mid-big", "new-huge", "old-small"}},
+		{CleanOptions{Order: OrderScore, AgeWeight: 1, SizeWeight: 0}, []string{"old-small", "mid-big", "new-huge"}},
+		{CleanOptions{Order: OrderScore, AgeWeight: 0, SizeWeight: 1}, []string{"new-huge", "mid-big", "old-small"}},
+	}
+
+	for _, test := range tests {
+		files := newFiles()
+		sortCandidates(files, test.opts, nil)
+		got := paths(files)
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("sortCandidates(order=%q, weights=%v/%v) = %v; want %v",
+					test.opts.Order, test.opts.AgeWeight, test.opts.SizeWeight, got, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	for _, order := range []string{"", "oldest", "largest", "score"} {
+		if err := validateOrder(order); err != nil {
+			t.Errorf("validateOrder(%q) returned unexpected error: %v", order, err)
+		}
+	}
+	if err := validateOrder("newest"); err == nil {
+		t.Errorf("validateOrder(%q) should have returned an error", "newest")
+	}
+}
+
+func TestFairShareOrder_Weights(t *testing.T) {
+	files := []FileInfo{
+		{Path: "a1", Size: 100, Tenant: "/srv/a"},
+		{Path: "b1", Size: 100, Tenant: "/srv/b"},
+		{Path: "a2", Size: 100, Tenant: "/srv/a"},
+		{Path: "b2", Size: 100, Tenant: "/srv/b"},
+	}
+	usage := map[string]uint64{"/srv/a": 200, "/srv/b": 200}
+
+	// Tenant a is entitled to three times the space of b, so b goes first
+	fairShareOrder(files, usage, map[string]float64{"a": 3})
+	got := paths(files)
+	expected := []string{"b1", "b2", "a1", "a2"}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("fairShareOrder = %v; want %v", got, expected)
+			break
+		}
+	}
+}
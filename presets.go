@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// preset is a built-in location for a well-known cache. Dirs starting with
+// "~/" are resolved against the home directory of every user.
+type preset struct {
+	Name        string
+	Description string
+	Dirs        []string
+	Include     []string
+	Exclude     []string
+	Order       string
+	AgeSource   string
+	MinAge      time.Duration
+	KeepLatest  int
+	GroupBy     string
+	Sidecar     *SidecarRule
+}
+
+// presets lists the built-in presets in the order they are documented
+var presets = []preset{
+	{
+		Name:        "go-build",
+		Description: "Go build cache; go refreshes the mtime of entries it uses",
+		Dirs:        []string{"~/.cache/go-build"},
+		Exclude:     []string{"README", "trim.txt"},
+		Order:       OrderOldest,
+		MinAge:      time.Hour,
+	},
+	{
+		Name:        "npm",
+		Description: "npm package cache",
+		Dirs:        []string{"~/.npm/_cacache"},
+		Exclude:     []string{"tmp/"},
+		Order:       OrderOldest,
+		AgeSource:   AgeSourceAtime,
+		MinAge:      time.Hour,
+	},
+	{
+		Name:        "pip",
+		Description: "pip HTTP and wheel cache",
+		Dirs:        []string{"~/.cache/pip"},
+		Exclude:     []string{"selfcheck/"},
+		Order:       OrderOldest,
+		AgeSource:   AgeSourceAtime,
+		MinAge:      time.Hour,
+	},
+	{
+		Name:        "cargo-registry",
+		Description: "Downloaded crates of the cargo registry; extracted sources are left alone",
+		Dirs:        []string{"~/.cargo/registry/cache"},
+		Include:     []string{"*.crate"},
+		Order:       OrderOldest,
+		AgeSource:   AgeSourceAtime,
+		MinAge:      time.Hour,
+	},
+	{
+		Name:        "ccache",
+		Description: "ccache compiler cache; ccache refreshes the mtime of entries it uses",
+		Dirs:        []string{"~/.cache/ccache", "~/.ccache"},
+		Exclude:     []string{"ccache.conf", "stats", "*.lock", cacheDirTagName},
+		Order:       OrderOldest,
+		MinAge:      time.Hour,
+	},
+	{
+		Name:        "pacman",
+		Description: "pacman package cache; keeps the two newest versions of each package",
+		Dirs:        []string{"/var/cache/pacman/pkg"},
+		Include:     []string{"*.pkg.tar*"},
+		Exclude:     []string{"download-*/"},
+		Order:       OrderOldest,
+		KeepLatest:  2,
+		GroupBy:     `^(.+)-[^-]+-[^-]+-[^-]+\.pkg\.tar`,
+		Sidecar:     &SidecarRule{Primary: []string{"*.pkg.tar.zst", "*.pkg.tar.xz", "*.pkg.tar.gz"}},
+	},
+	{
+		Name:        "apt",
+		Description: "apt package archives; keeps the newest version of each package",
+		Dirs:        []string{"/var/cache/apt/archives"},
+		Include:     []string{"*.deb"},
+		Exclude:     []string{"partial/"},
+		Order:       OrderOldest,
+		KeepLatest:  1,
+		GroupBy:     `^([^_]+)_`,
+	},
+}
+
+// findPreset looks up a preset by name
+func findPreset(name string) (preset, bool) {
+	for _, p := range presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return preset{}, false
+}
+
+// resolveDirs returns the preset's directories that exist, with "~/" expanded
+// for each home directory
+func (p preset) resolveDirs(homes []string) []string {
+	var dirs []string
+	for _, d := range p.Dirs {
+		candidates := []string{d}
+		if strings.HasPrefix(d, "~/") {
+			candidates = nil
+			for _, home := range homes {
+				candidates = append(candidates, filepath.Join(home, d[2:]))
+			}
+		}
+		for _, c := range candidates {
+			if info, err := os.Stat(c); err == nil && info.IsDir() {
+				dirs = append(dirs, c)
+			}
+		}
+	}
+	return dirs
+}
+
+// expandPreset applies a location's preset. The preset's directories are
+// added to target_dirs, and its filters and ordering fill in whatever the
+// location leaves unset. Home directories may live on different filesystems,
+// so the result holds one location per filesystem.
+func expandPreset(loc LocationConfig, homes []string) ([]LocationConfig, error) {
+	if loc.Preset == "" {
+		return []LocationConfig{loc}, nil
+	}
+	p, ok := findPreset(loc.Preset)
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q (see -presets)", loc.Preset)
+	}
+
+	loc.Include = append(append([]string(nil), loc.Include...), p.Include...)
+	loc.Exclude = append(append([]string(nil), loc.Exclude...), p.Exclude...)
+	if loc.Order == "" {
+		loc.Order = p.Order
+	}
+	if loc.AgeSource == "" {
+		loc.AgeSource = p.AgeSource
+	}
+	if loc.MinAge.Duration == 0 {
+		loc.MinAge.Duration = p.MinAge
+	}
+	if loc.KeepLatest == 0 && loc.GroupBy == "" {
+		loc.KeepLatest, loc.GroupBy = p.KeepLatest, p.GroupBy
+	}
+	if loc.Sidecar == nil {
+		loc.Sidecar = p.Sidecar
+	}
+
+	dirs := append(append([]string(nil), loc.TargetDirs...), p.resolveDirs(homes)...)
+	if len(dirs) == 0 {
+		loc.TargetDirs = nil
+		return []LocationConfig{loc}, nil
+	}
+
+	// Group the directories by filesystem, keeping their order
+	var groups [][]string
+	groupOf := make(map[uint64]int)
+	for _, d := range dirs {
+		dev, ok := deviceOf(d)
+		if !ok {
+			dev = 0
+		}
+		i, seen := groupOf[dev]
+		if !seen {
+			i = len(groups)
+			groupOf[dev] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], d)
+	}
+
+	expanded := make([]LocationConfig, len(groups))
+	for i, g := range groups {
+		expanded[i] = loc
+		expanded[i].TargetDirs = g
+	}
+	return expanded, nil
+}
+
+// userHomes returns the home directories presets are resolved against: those
+// of all accounts when running as root, otherwise the current user's
+func userHomes() []string {
+	if os.Geteuid() == 0 {
+		if homes, err := passwdHomes("/etc/passwd"); err == nil && len(homes) > 0 {
+			return homes
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return []string{home}
+	}
+	return nil
+}
+
+// passwdHomes reads the distinct home directories from a passwd file
+func passwdHomes(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var homes []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		home := filepath.Clean(fields[5])
+		if fields[5] == "" || home == "/" || seen[home] {
+			continue
+		}
+		seen[home] = true
+		homes = append(homes, home)
+	}
+	return homes, scanner.Err()
+}
+
+// printPresets writes what each preset expands to, in configuration syntax,
+// along with the directories it currently resolves to
+func printPresets(w io.Writer, homes []string) {
+	quote := func(list []string) string {
+		quoted := make([]string, len(list))
+		for i, s := range list {
+			quoted[i] = fmt.Sprintf("%q", s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+
+	for i, p := range presets {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s: %s\n", p.Name, p.Description)
+		fmt.Fprintf(w, "  target_dirs = %s\n", quote(p.Dirs))
+		if len(p.Include) > 0 {
+			fmt.Fprintf(w, "  include = %s\n", quote(p.Include))
+		}
+		if len(p.Exclude) > 0 {
+			fmt.Fprintf(w, "  exclude = %s\n", quote(p.Exclude))
+		}
+		fmt.Fprintf(w, "  order = %q\n", p.Order)
+		if p.AgeSource != "" {
+			fmt.Fprintf(w, "  age_source = %q\n", p.AgeSource)
+		}
+		if p.MinAge > 0 {
+			fmt.Fprintf(w, "  min_age = %q\n", p.MinAge.String())
+		}
+		if p.KeepLatest > 0 {
+			fmt.Fprintf(w, "  keep_latest = %d\n", p.KeepLatest)
+			fmt.Fprintf(w, "  group_by = '%s'\n", p.GroupBy)
+		}
+		if p.Sidecar != nil {
+			fmt.Fprintf(w, "  sidecar = { primary = %s }\n", quote(p.Sidecar.Primary))
+		}
+		if dirs := p.resolveDirs(homes); len(dirs) > 0 {
+			fmt.Fprintf(w, "  # Resolves to: %s\n", strings.Join(dirs, ", "))
+		} else {
+			fmt.Fprintf(w, "  # No matching directories on this system\n")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandPreset(t *testing.T) {
+	alice := t.TempDir()
+	bob := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(alice, ".cache", "go-build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	loc := LocationConfig{Preset: "go-build", Exclude: []string{"keep-*"}}
+	expanded, err := expandPreset(loc, []string{alice, bob})
+	if err != nil {
+		t.Fatalf("expandPreset failed: %v", err)
+	}
+	if len(expanded) != 1 {
+		t.Fatalf("Expected one location, got %d", len(expanded))
+	}
+	got := expanded[0]
+	if len(got.TargetDirs) != 1 || got.TargetDirs[0] != filepath.Join(alice, ".cache", "go-build") {
+		t.Errorf("Expected only alice's build cache, got %v", got.TargetDirs)
+	}
+	if len(got.Exclude) != 3 || got.Exclude[0] != "keep-*" {
+		t.Errorf("Expected location excludes before the preset's, got %v", got.Exclude)
+	}
+	if got.MinAge.Duration != time.Hour || got.Order != OrderOldest {
+		t.Errorf("Expected preset defaults, got min_age %v, order %q", got.MinAge.Duration, got.Order)
+	}
+
+	// Settings of the location win over the preset
+	loc = LocationConfig{Preset: "apt", KeepLatest: 3, MinAge: duration{time.Minute}}
+	expanded, err = expandPreset(loc, nil)
+	if err != nil {
+		t.Fatalf("expandPreset failed: %v", err)
+	}
+	if expanded[0].KeepLatest != 3 || expanded[0].GroupBy != "" || expanded[0].MinAge.Duration != time.Minute {
+		t.Errorf("Expected location settings to override the preset, got %+v", expanded[0])
+	}
+
+	if _, err := expandPreset(LocationConfig{Preset: "maven"}, nil); err == nil {
+		t.Errorf("Expected error for unknown preset")
+	}
+}
+
+func TestPresetsResolve(t *testing.T) {
+	for _, p := range presets {
+		expanded, err := expandPreset(LocationConfig{Preset: p.Name}, nil)
+		if err != nil {
+			t.Errorf("%s: expandPreset failed: %v", p.Name, err)
+			continue
+		}
+		if _, err := resolveCleanOptions(GlobalConfig{}, expanded[0]); err != nil {
+			t.Errorf("%s: invalid preset: %v", p.Name, err)
+		}
+	}
+
+	var out bytes.Buffer
+	printPresets(&out, nil)
+	for _, p := range presets {
+		if !strings.Contains(out.String(), p.Name+": ") {
+			t.Errorf("Preset listing is missing %s", p.Name)
+		}
+	}
+}
+
+func TestPasswdHomes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	content := "root:x:0:0:root:/root:/bin/bash\n" +
+		"daemon:x:1:1:daemon:/:/usr/sbin/nologin\n" +
+		"alice:x:1000:1000::/home/alice:/bin/bash\n" +
+		"alice2:x:1001:1001::/home/alice/:/bin/bash\n" +
+		"broken line\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	homes, err := passwdHomes(path)
+	if err != nil {
+		t.Fatalf("passwdHomes failed: %v", err)
+	}
+	if len(homes) != 2 || homes[0] != "/root" || homes[1] != "/home/alice" {
+		t.Errorf("passwdHomes = %v; want [/root /home/alice]", homes)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procFD describes a file descriptor held open by a process
+type procFD struct {
+	PID    int
+	FD     int
+	Path   string // /proc/<pid>/fd/<n>
+	Target string // Link target as reported by the kernel
+	Info   os.FileInfo
+}
+
+// listProcFDs returns the regular files held open by all processes visible in
+// /proc. Processes we are not allowed to inspect are silently skipped.
+func listProcFDs() ([]procFD, error) {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []procFD
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || !p.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join("/proc", p.Name(), "fd")
+		entries, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue // Process exited or permission denied
+		}
+		for _, e := range entries {
+			fd, err := strconv.Atoi(e.Name())
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(fdDir, e.Name())
+			target, err := os.Readlink(path)
+			if err != nil {
+				continue
+			}
+			// Stat follows the magic link, even for deleted files
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			fds = append(fds, procFD{PID: pid, FD: fd, Path: path, Target: target, Info: info})
+		}
+	}
+	return fds, nil
+}
+
+// openFiles returns the set of files currently held open by any process
+func openFiles() (map[fileID]bool, error) {
+	fds, err := listProcFDs()
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[fileID]bool)
+	for _, fd := range fds {
+		if st, ok := statFile(fd.Info); ok {
+			open[fileID{st.Dev, st.Ino}] = true
+		}
+	}
+	return open, nil
+}
+
+// deletedFile is a deleted file that a process still holds open. Its space is
+// only released once every descriptor is closed.
+type deletedFile struct {
+	PID  int
+	Path string // /proc/<pid>/fd/<n>, still usable to truncate the file
+	Name string // Original path of the deleted file
+	Size uint64 // Allocated space held by the file
+}
+
+// deletedOpenFiles returns the deleted-but-open files on device dev. A file
+// held by several descriptors is reported once per descriptor, but only the
+// first carries its size.
+func deletedOpenFiles(dev uint64) ([]deletedFile, error) {
+	fds, err := listProcFDs()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[fileID]bool)
+	var deleted []deletedFile
+	for _, fd := range fds {
+		st, ok := statFile(fd.Info)
+		if !ok || st.Dev != dev || st.Nlink != 0 || !strings.HasSuffix(fd.Target, " (deleted)") {
+			continue
+		}
+		df := deletedFile{
+			PID:  fd.PID,
+			Path: fd.Path,
+			Name: strings.TrimSuffix(fd.Target, " (deleted)"),
+		}
+		id := fileID{st.Dev, st.Ino}
+		if !seen[id] {
+			seen[id] = true
+			df.Size = uint64(st.Blocks) * 512
+		}
+		deleted = append(deleted, df)
+	}
+	return deleted, nil
+}
+
+// truncateDeletedFile releases the space of a deleted-but-open file through the
+// holding process's descriptor.
+func truncateDeletedFile(f deletedFile) error {
+	return os.Truncate(f.Path, 0)
+}
+
+// lockedFiles returns the set of files holding POSIX, OFD or flock advisory
+// locks, as listed in /proc/locks.
+func lockedFiles() (map[fileID]bool, error) {
+	data, err := os.ReadFile("/proc/locks")
+	if err != nil {
+		return nil, err
+	}
+	return parseProcLocks(string(data)), nil
+}
+
+// parseProcLocks parses /proc/locks lines such as
+//
+//	1: POSIX  ADVISORY  WRITE 1234 08:01:131090 0 EOF
+//	2: -> FLOCK  ADVISORY  WRITE 5678 00:2a:9821 0 EOF
+//
+// where the device is given as hex major:minor and the inode in decimal.
+func parseProcLocks(data string) map[fileID]bool {
+	locked := make(map[fileID]bool)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == "->" {
+			fields = append(fields[:1], fields[2:]...) // Blocked waiter
+		}
+		if len(fields) < 6 {
+			continue
+		}
+		parts := strings.Split(fields[5], ":")
+		if len(parts) != 3 {
+			continue
+		}
+		major, err1 := strconv.ParseUint(parts[0], 16, 32)
+		minor, err2 := strconv.ParseUint(parts[1], 16, 32)
+		ino, err3 := strconv.ParseUint(parts[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		locked[fileID{mkdev(major, minor), ino}] = true
+	}
+	return locked
+}
+
+// mkdev encodes a major/minor pair the way the kernel reports st_dev
+func mkdev(major, minor uint64) uint64 {
+	return (major&0xfffff000)<<32 | (major&0xfff)<<8 | (minor&0xffffff00)<<12 | minor&0xff
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCleanUp_SkipOpenFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	openPath := filepath.Join(tempDir, "recording.ts")
+	closedPath := filepath.Join(tempDir, "old.ts")
+	for _, path := range []string{openPath, closedPath} {
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(openPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = CleanUp([]string{tempDir}, allocated(t, openPath, closedPath), 0, CleanOptions{SkipOpenFiles: true})
+	if err == nil {
+		t.Fatalf("Expected error since the open file cannot be deleted")
+	}
+	if _, err := os.Stat(openPath); err != nil {
+		t.Errorf("recording.ts is open and should still exist")
+	}
+	if _, err := os.Stat(closedPath); !os.IsNotExist(err) {
+		t.Errorf("old.ts should have been deleted")
+	}
+}
+
+func TestDeletedOpenFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "app.log")
+	if err := os.WriteFile(path, make([]byte, 64*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dev, ok := deviceOf(tempDir)
+	if !ok {
+		t.Fatalf("Failed to get device of %s", tempDir)
+	}
+	deleted, err := deletedOpenFiles(dev)
+	if err != nil {
+		t.Fatalf("deletedOpenFiles failed: %v", err)
+	}
+
+	var found *deletedFile
+	for i := range deleted {
+		if deleted[i].PID == os.Getpid() && deleted[i].Name == path {
+			found = &deleted[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("Expected %s to be reported as deleted but open, got %+v", path, deleted)
+	}
+	if found.Size == 0 {
+		t.Errorf("Expected deleted file to hold space")
+	}
+
+	if err := truncateDeletedFile(*found); err != nil {
+		t.Fatalf("truncateDeletedFile failed: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("Expected deleted file to be truncated, size is %d", info.Size())
+	}
+}
+
+func TestParseProcLocks(t *testing.T) {
+	data := `1: POSIX  ADVISORY  WRITE 1234 08:01:131090 0 EOF
+2: -> FLOCK  ADVISORY  WRITE 5678 00:2a:9821 0 EOF
+3: OFDLCK ADVISORY  READ  -1 103:02:42 0 EOF
+`
+	locked := parseProcLocks(data)
+	for _, id := range []fileID{
+		{mkdev(0x08, 0x01), 131090},
+		{mkdev(0x00, 0x2a), 9821},
+		{mkdev(0x103, 0x02), 42},
+	} {
+		if !locked[id] {
+			t.Errorf("Expected %+v to be locked", id)
+		}
+	}
+	if len(locked) != 3 {
+		t.Errorf("Expected 3 locked files, got %d", len(locked))
+	}
+}
+
+func TestCleanUp_SkipLocked(t *testing.T) {
+	tempDir := t.TempDir()
+
+	lockedPath := filepath.Join(tempDir, "segment.lck")
+	if err := os.WriteFile(lockedPath, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(lockedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		t.Skipf("flock not supported: %v", err)
+	}
+
+	err = CleanUp([]string{tempDir}, 1000, 0, CleanOptions{SkipLocked: true, DryRun: true})
+	if err == nil {
+		t.Fatalf("Expected error since the locked file cannot be deleted")
+	}
+
+	err = CleanUp([]string{tempDir}, 1000, 0, CleanOptions{SkipLocked: true})
+	if err == nil {
+		t.Fatalf("Expected error since the locked file cannot be deleted")
+	}
+	if _, err := os.Stat(lockedPath); err != nil {
+		t.Errorf("segment.lck holds a lock and should still exist")
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errProcUnsupported = errors.New("process inspection is only supported on Linux")
+
+// openFiles is only supported on Linux
+func openFiles() (map[fileID]bool, error) {
+	return nil, errProcUnsupported
+}
+
+// deletedFile is a deleted file that a process still holds open
+type deletedFile struct {
+	PID  int
+	Path string
+	Name string
+	Size uint64
+}
+
+// deletedOpenFiles is only supported on Linux
+func deletedOpenFiles(dev uint64) ([]deletedFile, error) {
+	return nil, errProcUnsupported
+}
+
+// truncateDeletedFile is only supported on Linux
+func truncateDeletedFile(f deletedFile) error {
+	return errProcUnsupported
+}
+
+// lockedFiles is only supported on Linux
+func lockedFiles() (map[fileID]bool, error) {
+	return nil, errProcUnsupported
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// protectYoung splits off candidates holding any file written after cutoff. A
+// unit is protected as a whole so that min_age never deletes a recent file,
+// whatever age the unit itself is ranked by. It returns the remaining
+// candidates, the protected ones and their size.
+func protectYoung(files []FileInfo, cutoff int64) ([]FileInfo, []FileInfo, uint64) {
+	var young []FileInfo
+	var bytes uint64
+	kept := files[:0]
+	for _, f := range files {
+		isYoung := false
+		for _, m := range f.memberFiles() {
+			if m.Written > cutoff {
+				isYoung = true
+				break
+			}
+		}
+		if isYoung {
+			young = append(young, f)
+			bytes += uint64(f.Size)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept, young, bytes
+}
+
+// protectMarked drops candidates marked with the user.vacuum.keep xattr. A
+// unit is kept as a whole if any of its files is marked. It returns the
+// remaining candidates and the number and size of the kept ones.
+func protectMarked(files []FileInfo) ([]FileInfo, int, uint64) {
+	var count int
+	var bytes uint64
+	kept := files[:0]
+	for _, f := range files {
+		marked := false
+		for _, m := range f.memberFiles() {
+			if m.Keep {
+				marked = true
+				break
+			}
+		}
+		if marked {
+			count++
+			bytes += uint64(f.Size)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept, count, bytes
+}
+
+// keepLatest protects the newest n files of each group from deletion. Files are
+// grouped by directory and, with groupBy set, by the first capture group (or
+// whole match) of groupBy against the file name. young holds files already
+// protected by min_age; they are the newest of their groups and count towards n.
+// It returns the remaining candidates and the number and size of protected files.
+func keepLatest(files, young []FileInfo, n int, groupBy *regexp.Regexp) ([]FileInfo, int, uint64) {
+	if n <= 0 {
+		return files, 0, 0
+	}
+
+	keep := make(map[string]int)
+	for _, f := range young {
+		keep[groupKey(f.Path, groupBy)]--
+	}
+
+	// Visit candidates newest first
+	byAge := make([]int, len(files))
+	for i := range byAge {
+		byAge[i] = i
+	}
+	sortIndexesByAge(byAge, files)
+
+	protected := make([]bool, len(files))
+	var count int
+	var bytes uint64
+	for k := len(byAge) - 1; k >= 0; k-- {
+		i := byAge[k]
+		key := groupKey(files[i].Path, groupBy)
+		if n+keep[key] > 0 {
+			keep[key]--
+			protected[i] = true
+			count++
+			bytes += uint64(files[i].Size)
+		}
+	}
+
+	kept := files[:0]
+	for i, f := range files {
+		if !protected[i] {
+			kept = append(kept, f)
+		}
+	}
+	return kept, count, bytes
+}
+
+// groupKey returns the keep_latest group of a file
+func groupKey(path string, groupBy *regexp.Regexp) string {
+	dir := filepath.Dir(path)
+	if groupBy == nil {
+		return dir
+	}
+	m := groupBy.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return dir
+	}
+	if len(m) > 1 {
+		return dir + "\x00" + m[1]
+	}
+	return dir + "\x00" + m[0]
+}
+
+// compileGroupBy compiles a group_by pattern, returning nil when unset
+func compileGroupBy(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid group_by: %w", err)
+	}
+	return re, nil
+}
+
+// RetentionPolicy is a grandfather-father-son retention policy. For each of the
+// most recent Daily days (Weekly weeks, ...) that have files, the newest file
+// of that period is kept.
+type RetentionPolicy struct {
+	Daily   int  `toml:"daily"`
+	Weekly  int  `toml:"weekly"`
+	Monthly int  `toml:"monthly"`
+	Yearly  int  `toml:"yearly"`
+	Always  bool `toml:"always"` // Delete everything else on every check, not only when space is needed
+}
+
+// retentionBucket is one period of a retention policy
+type retentionBucket struct {
+	name  string
+	count int                    // Number of periods to keep
+	key   func(time.Time) string // Identifies the period a time falls in
+}
+
+// buckets returns the periods of the policy, with how many of each to keep
+func (p *RetentionPolicy) buckets() []retentionBucket {
+	return []retentionBucket{
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// validateRetention checks that a retention policy keeps something and has no
+// negative counts
+func validateRetention(p *RetentionPolicy) error {
+	if p == nil {
+		return nil
+	}
+	var any bool
+	for _, b := range p.buckets() {
+		if b.count < 0 {
+			return fmt.Errorf("retention %s must not be negative", b.name)
+		}
+		any = any || b.count > 0
+	}
+	if !any {
+		return fmt.Errorf("retention requires a positive daily, weekly, monthly or yearly count")
+	}
+	return nil
+}
+
+// classifyRetention applies a retention policy to the candidates. young holds
+// files already protected by min_age, which fill buckets like any other file.
+// It returns the files the policy keeps, mapped to the buckets they fill
+// (e.g. "daily 2024-05-01").
+func classifyRetention(files, young []FileInfo, policy *RetentionPolicy) map[string][]string {
+	all := append(append([]FileInfo(nil), files...), young...)
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Age > all[j].Age // Newest first
+	})
+
+	kept := make(map[string][]string)
+	for _, b := range policy.buckets() {
+		if b.count <= 0 {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, f := range all {
+			if len(seen) >= b.count {
+				break
+			}
+			period := b.key(time.Unix(f.Age, 0))
+			if seen[period] {
+				continue
+			}
+			seen[period] = true
+			kept[f.Path] = append(kept[f.Path], b.name+" "+period)
+		}
+	}
+	return kept
+}
+
+// applyRetention removes the files kept by the policy from the candidates.
+// With policy.Always set, every other file is marked expired so it is deleted
+// regardless of free space.
+func applyRetention(files, young []FileInfo, policy *RetentionPolicy, dryRun, humanReadable bool) ([]FileInfo, int, uint64) {
+	if policy == nil {
+		return files, 0, 0
+	}
+
+	kept := classifyRetention(files, young, policy)
+	var count int
+	var bytes uint64
+	remaining := files[:0]
+	for _, f := range files {
+		if buckets, ok := kept[f.Path]; ok {
+			count++
+			bytes += uint64(f.Size)
+			if dryRun {
+				fmt.Printf("[DRY RUN] Retaining %s (%s)\n", f.Path, strings.Join(buckets, ", "))
+			}
+			continue
+		}
+		if policy.Always {
+			f.Expired = true
+		}
+		if dryRun && policy.Always {
+			fmt.Printf("[DRY RUN] Retention policy would delete %s\n", f.Path)
+		} else if dryRun {
+			fmt.Printf("[DRY RUN] Not retaining %s (deleted when space is needed)\n", f.Path)
+		}
+		remaining = append(remaining, f)
+	}
+	if count > 0 {
+		fmt.Printf("Retention policy keeps %d files (size: %s)\n", count, sizeString(bytes, humanReadable))
+	}
+	return remaining, count, bytes
+}
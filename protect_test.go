@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanUp_KeepLatest(t *testing.T) {
+	tempDir := t.TempDir()
+	cam := filepath.Join(tempDir, "cam1")
+	if err := os.Mkdir(cam, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var clips []string
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(cam, fmt.Sprintf("clip%d.mp4", i))
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Duration(5-i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		clips = append(clips, path)
+	}
+
+	// The newest clip is also protected by min_age and counts towards keep_latest
+	opts := CleanOptions{KeepLatest: 2, MinAge: 90 * time.Minute}
+	err := CleanUp([]string{tempDir}, allocated(t, clips...), 0, opts)
+	if err == nil || !strings.Contains(err.Error(), "keep_latest") {
+		t.Fatalf("Expected keep_latest error, got %v", err)
+	}
+	for i, path := range clips {
+		_, err := os.Stat(path)
+		if i < 3 && !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", path)
+		}
+		if i >= 3 && err != nil {
+			t.Errorf("%s should still exist", path)
+		}
+	}
+}
+
+func TestKeepLatest_GroupBy(t *testing.T) {
+	now := time.Now()
+	files := []FileInfo{
+		{Path: "/builds/app-1.tar.gz", Age: now.Add(-4 * time.Hour).Unix()},
+		{Path: "/builds/app-2.tar.gz", Age: now.Add(-3 * time.Hour).Unix()},
+		{Path: "/builds/lib-1.tar.gz", Age: now.Add(-2 * time.Hour).Unix()},
+		{Path: "/builds/lib-2.tar.gz", Age: now.Add(-1 * time.Hour).Unix()},
+		{Path: "/builds/notes.txt", Age: now.Add(-5 * time.Hour).Unix()},
+	}
+
+	groupBy, err := compileGroupBy(`^(.+)-\d+\.tar\.gz$`)
+	if err != nil {
+		t.Fatalf("compileGroupBy failed: %v", err)
+	}
+
+	kept, count, _ := keepLatest(files, nil, 1, groupBy)
+	if count != 3 {
+		t.Errorf("Expected 3 protected files, got %d", count)
+	}
+	got := paths(kept)
+	expected := []string{"/builds/app-1.tar.gz", "/builds/lib-1.tar.gz"}
+	if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("keepLatest candidates = %v; want %v", got, expected)
+	}
+
+	if _, err := compileGroupBy(`(`); err == nil {
+		t.Errorf("Expected error for invalid group_by")
+	}
+}
+
+func TestClassifyRetention(t *testing.T) {
+	// One backup per day for 60 days, plus a second one on the newest day
+	base := time.Date(2024, 5, 31, 12, 0, 0, 0, time.Local)
+	var files []FileInfo
+	for i := 0; i < 60; i++ {
+		day := base.AddDate(0, 0, -i)
+		files = append(files, FileInfo{Path: day.Format("dump-2006-01-02.sql"), Age: day.Unix()})
+	}
+	files = append(files, FileInfo{Path: "dump-2024-05-31-early.sql", Age: base.Add(-6 * time.Hour).Unix()})
+
+	kept := classifyRetention(files, nil, &RetentionPolicy{Daily: 7, Weekly: 4, Monthly: 3})
+
+	// 7 dailies (May 25-31), Sunday weeklies for May 12/19 on top of the
+	// dailies, and the last dump of April. The dumps only go back to April 2,
+	// so there is no third month to keep.
+	for _, name := range []string{
+		"dump-2024-05-31.sql", "dump-2024-05-25.sql",
+		"dump-2024-05-19.sql", "dump-2024-05-12.sql",
+		"dump-2024-04-30.sql",
+	} {
+		if _, ok := kept[name]; !ok {
+			t.Errorf("Expected %s to be retained", name)
+		}
+	}
+	for _, name := range []string{"dump-2024-05-31-early.sql", "dump-2024-05-24.sql", "dump-2024-04-29.sql", "dump-2024-04-02.sql"} {
+		if buckets, ok := kept[name]; ok {
+			t.Errorf("Expected %s not to be retained, got %v", name, buckets)
+		}
+	}
+	if got := kept["dump-2024-05-31.sql"]; len(got) != 3 {
+		t.Errorf("Expected newest dump to fill daily, weekly and monthly buckets, got %v", got)
+	}
+	if len(kept) != 7+2+1 {
+		t.Errorf("Expected 10 retained files, got %d", len(kept))
+	}
+}
+
+func TestCleanUp_RetentionAlways(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var dumps []string
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("dump%d.sql", i))
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().AddDate(0, 0, -(4-i)*2)); err != nil {
+			t.Fatal(err)
+		}
+		dumps = append(dumps, path)
+	}
+
+	// Plenty of space, but the policy only keeps the two newest daily dumps
+	opts := CleanOptions{Retention: &RetentionPolicy{Daily: 2, Always: true}}
+	if err := CleanUp([]string{tempDir}, 0, 1000, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	for i, path := range dumps {
+		_, err := os.Stat(path)
+		if i < 2 && !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", path)
+		}
+		if i >= 2 && err != nil {
+			t.Errorf("%s should still exist", path)
+		}
+	}
+}
@@ -5,4 +5,18 @@ type DiskUsage struct {
 	Total uint64
 	Free  uint64
 	Used  uint64
+
+	// Inode counts; zero when the filesystem does not report them
+	TotalInodes uint64
+	FreeInodes  uint64
+}
+
+// fileStat contains the platform-specific details of a file used for space accounting
+type fileStat struct {
+	Dev    uint64
+	Ino    uint64
+	Nlink  uint64
+	Blocks int64 // Number of 512-byte blocks allocated
+	Atime  int64 // Unix timestamps of last access and last status change
+	Ctime  int64
 }
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Supported values of CleanOptions.Unit
+const (
+	UnitFile      = "file"
+	UnitDirectory = "directory"
+)
+
+// Supported values of CleanOptions.UnitAge
+const (
+	UnitAgeNewest = "newest" // Age of the newest file in the directory
+	UnitAgeMtime  = "mtime"  // Modification time of the directory itself
+)
+
+// SidecarRule groups companion files with their primary file, e.g. a video's
+// subtitles and thumbnail. By default a file is a sidecar of the primary in the
+// same directory whose name without extension it starts with, followed by a
+// dot: clip.srt and clip.thumb.jpg belong to clip.mp4. With Key set, files are
+// grouped by the first capture group (or whole match) of Key instead.
+type SidecarRule struct {
+	Primary []string `toml:"primary"` // Glob patterns of primary file names, e.g. "*.mp4"
+	Key     string   `toml:"key"`     // Optional regex over the file name naming the unit
+}
+
+// sidecarMatcher is a compiled SidecarRule
+type sidecarMatcher struct {
+	primary []string
+	key     *regexp.Regexp
+}
+
+// compileSidecarRule validates a sidecar rule, returning nil when unset
+func compileSidecarRule(rule *SidecarRule) (*sidecarMatcher, error) {
+	if rule == nil {
+		return nil, nil
+	}
+	if len(rule.Primary) == 0 {
+		return nil, fmt.Errorf("sidecar requires at least one primary pattern")
+	}
+	for _, p := range rule.Primary {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid sidecar primary pattern %q: %w", p, err)
+		}
+	}
+	m := &sidecarMatcher{primary: rule.Primary}
+	if rule.Key != "" {
+		re, err := regexp.Compile(rule.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sidecar key: %w", err)
+		}
+		m.key = re
+	}
+	return m, nil
+}
+
+// isPrimary reports whether a file name matches a primary pattern
+func (m *sidecarMatcher) isPrimary(name string) bool {
+	for _, p := range m.primary {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// keyOf returns the unit key of a file name under the Key regex
+func (m *sidecarMatcher) keyOf(name string) (string, bool) {
+	match := m.key.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+	if len(match) > 1 {
+		return match[1], true
+	}
+	return match[0], true
+}
+
+// sidecarUnits merges every primary file with its sidecars into one candidate.
+// The unit takes its age, tier and tenant from the primary (the newest one if
+// a Key groups several), and its size is the sum of its members. The primary
+// comes first among the members so it is deleted first. Files without a
+// primary remain single candidates.
+func sidecarUnits(files []FileInfo, m *sidecarMatcher) []FileInfo {
+	// Index primaries by directory and unit key
+	primaries := make(map[string]int)
+	members := make(map[int][]int)
+	for i, f := range files {
+		name := filepath.Base(f.Path)
+		if !m.isPrimary(name) {
+			continue
+		}
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if m.key != nil {
+			var ok bool
+			if stem, ok = m.keyOf(name); !ok {
+				continue
+			}
+		}
+		key := filepath.Dir(f.Path) + "\x00" + stem
+		if p, ok := primaries[key]; ok {
+			if f.Age > files[p].Age {
+				// The newest primary leads the unit
+				members[i] = append([]int{p}, members[p]...)
+				delete(members, p)
+				primaries[key] = i
+				continue
+			}
+			members[p] = append(members[p], i)
+			continue
+		}
+		primaries[key] = i
+		members[i] = nil
+	}
+
+	// Attach every other file to its primary
+	owner := make(map[int]int)
+	for _, p := range primaries {
+		for _, i := range members[p] {
+			owner[i] = p
+		}
+	}
+	for i, f := range files {
+		if _, ok := members[i]; ok {
+			continue
+		}
+		if _, ok := owner[i]; ok {
+			continue
+		}
+		dir, name := filepath.Dir(f.Path), filepath.Base(f.Path)
+		if m.key != nil {
+			if stem, ok := m.keyOf(name); ok {
+				if p, ok := primaries[dir+"\x00"+stem]; ok {
+					owner[i] = p
+					members[p] = append(members[p], i)
+				}
+			}
+			continue
+		}
+		// The longest primary stem the name starts with, followed by a dot
+		for end := strings.LastIndex(name, "."); end > 0; end = strings.LastIndex(name[:end], ".") {
+			if p, ok := primaries[dir+"\x00"+name[:end]]; ok {
+				owner[i] = p
+				members[p] = append(members[p], i)
+				break
+			}
+		}
+	}
+
+	units := make([]FileInfo, 0, len(files)-len(owner))
+	for i, f := range files {
+		if _, ok := owner[i]; ok {
+			continue
+		}
+		if len(members[i]) == 0 {
+			units = append(units, f)
+			continue
+		}
+		unit := f
+		unit.Dev, unit.Ino, unit.Nlink = 0, 0, 0
+		unit.Members = []FileInfo{f}
+		for _, k := range members[i] {
+			unit.Members = append(unit.Members, files[k])
+			unit.Size += files[k].Size
+			unit.Reclaim += files[k].Reclaim
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// validateUnit checks the unit, unit_depth and unit_age settings
+func validateUnit(unit string, depth int, unitAge string) error {
+	switch unit {
+	case "", UnitFile, UnitDirectory:
+	default:
+		return fmt.Errorf("invalid unit %q (expected file or directory)", unit)
+	}
+	if depth < 0 {
+		return fmt.Errorf("unit_depth must not be negative")
+	}
+	switch unitAge {
+	case "", UnitAgeNewest, UnitAgeMtime:
+	default:
+		return fmt.Errorf("invalid unit_age %q (expected newest or mtime)", unitAge)
+	}
+	return nil
+}
+
+// memberFiles returns the files deleted along with f: the members of a unit,
+// or f itself for a single file.
+func (f FileInfo) memberFiles() []FileInfo {
+	if len(f.Members) > 0 {
+		return f.Members
+	}
+	return []FileInfo{f}
+}
+
+// unitDir returns the directory at depth levels below root that contains path.
+// It reports false for files that sit above that depth.
+func unitDir(root, path string, depth int) (string, bool) {
+	segs := strings.Split(relPath(root, path), "/")
+	if len(segs) <= depth {
+		return "", false
+	}
+	return filepath.Join(root, filepath.FromSlash(strings.Join(segs[:depth], "/"))), true
+}
+
+// directoryUnits turns the files collected for each unit directory into one
+// candidate per directory. Its size is the sum of its files and its age that of
+// its newest file, or of the directory itself with unitAge "mtime".
+func directoryUnits(members map[string][]FileInfo, unitAge string) []FileInfo {
+	var units []FileInfo
+	dirs := make([]string, 0, len(members))
+	for dir := range members {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		files := members[dir]
+		unit := FileInfo{
+			Path:    dir,
+			Tier:    files[0].Tier,
+			Tenant:  files[0].Tenant,
+			Cache:   files[0].Cache,
+			IsDir:   true,
+			Members: files,
+		}
+		// The directory expires once all of its files have, and goes as early
+		// as its most urgent file
+		var newest int64
+		unit.Expired, unit.Priority = true, files[0].Priority
+		for _, f := range files {
+			unit.Size += f.Size
+			unit.Reclaim += f.Reclaim
+			if f.Age > newest {
+				newest = f.Age
+			}
+			unit.Expired = unit.Expired && f.Expired
+			if f.Priority > unit.Priority {
+				unit.Priority = f.Priority
+			}
+		}
+		unit.Age = newest
+		if unitAge == UnitAgeMtime {
+			if info, err := os.Stat(dir); err == nil {
+				unit.Age = info.ModTime().Unix()
+			}
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// anyMemberIn reports whether f, or any member of a unit, is in ids
+func anyMemberIn(f FileInfo, ids map[fileID]bool) bool {
+	for _, m := range f.memberFiles() {
+		if m.Ino != 0 && ids[fileID{m.Dev, m.Ino}] {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteUnit deletes the files of a unit and returns the ones removed. Files
+// the filters leave alone stay in place, as do the directories themselves
+// until the empty directory pass. A sidecar unit's primary is deleted first,
+// and if that fails its sidecars are kept so the unit stays intact.
+func deleteUnit(unit FileInfo, dryRun, humanReadable bool) []FileInfo {
+	if dryRun {
+		if unit.IsDir {
+			fmt.Printf("[DRY RUN] Would delete directory %s (%d files, size: %s)\n",
+				unit.Path, len(unit.Members), sizeDetail(unit, humanReadable))
+		} else {
+			fmt.Printf("[DRY RUN] Would delete %s with %d sidecar files (size: %s)\n",
+				unit.Path, len(unit.Members)-1, sizeDetail(unit, humanReadable))
+		}
+		return unit.Members
+	}
+
+	var deleted []FileInfo
+	removed := FileInfo{Path: unit.Path}
+	for i, m := range unit.Members {
+		if err := os.Remove(m.Path); err != nil {
+			fmt.Printf("Failed to delete %s: %v\n", m.Path, err)
+			if i == 0 && !unit.IsDir {
+				return nil
+			}
+			continue
+		}
+		deleted = append(deleted, m)
+		removed.Size += m.Size
+		removed.Reclaim += m.Reclaim
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	if unit.IsDir {
+		fmt.Printf("Deleted directory %s (%d files, size: %s)\n",
+			unit.Path, len(deleted), sizeDetail(removed, humanReadable))
+	} else {
+		fmt.Printf("Deleted %s with %d sidecar files (size: %s)\n",
+			unit.Path, len(deleted)-1, sizeDetail(removed, humanReadable))
+	}
+	return deleted
+}
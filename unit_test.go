@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanUp_DirectoryUnits(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Each day's files are only useful together. Day 3 holds the oldest file
+	// but is still being written, day 1 has the oldest newest file.
+	ages := map[string]time.Duration{
+		"2024-05-01/a.bin": 6 * time.Hour,
+		"2024-05-01/b.bin": 5 * time.Hour,
+		"2024-05-02/a.bin": 10 * time.Hour,
+		"2024-05-02/b.bin": 3 * time.Hour,
+		"2024-05-03/a.bin": 20 * time.Hour,
+		"2024-05-03/b.bin": 30 * time.Minute,
+		"readme.txt":       100 * time.Hour,
+	}
+	for name, age := range ages {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := CleanOptions{Unit: UnitDirectory, UnitDepth: 1, MinAge: time.Hour}
+	if err := CleanUp([]string{tempDir}, 150, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "2024-05-01")); !os.IsNotExist(err) {
+		t.Errorf("2024-05-01 should have been deleted as a whole")
+	}
+	for _, name := range []string{"2024-05-02/a.bin", "2024-05-02/b.bin", "2024-05-03/a.bin", "readme.txt"} {
+		if _, err := os.Stat(filepath.Join(tempDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+}
+
+func TestDirectoryUnits_Mtime(t *testing.T) {
+	tempDir := t.TempDir()
+	dir := filepath.Join(tempDir, "job-1")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	members := map[string][]FileInfo{
+		dir: {
+			{Path: filepath.Join(dir, "a"), Size: 10, Reclaim: 8, Age: time.Now().Unix()},
+			{Path: filepath.Join(dir, "b"), Size: 20, Reclaim: 20, Age: time.Now().Unix()},
+		},
+	}
+	units := directoryUnits(members, UnitAgeMtime)
+	if len(units) != 1 {
+		t.Fatalf("Expected one unit, got %d", len(units))
+	}
+	u := units[0]
+	if u.Age != mtime.Unix() || u.Size != 30 || u.Reclaim != 28 || !u.IsDir {
+		t.Errorf("Unexpected unit: %+v", u)
+	}
+}
+
+func TestUnitDir(t *testing.T) {
+	tests := []struct {
+		path  string
+		depth int
+		want  string
+		ok    bool
+	}{
+		{"/data/2024/05/a.mp4", 1, "/data/2024", true},
+		{"/data/2024/05/a.mp4", 2, "/data/2024/05", true},
+		{"/data/2024/05/a.mp4", 3, "", false},
+		{"/data/a.mp4", 1, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := unitDir("/data", filepath.FromSlash(tt.path), tt.depth)
+		if ok != tt.ok || got != filepath.FromSlash(tt.want) {
+			t.Errorf("unitDir(%q, %d) = %q, %v; want %q, %v", tt.path, tt.depth, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if err := validateUnit("dataset", 1, ""); err == nil {
+		t.Errorf("Expected error for invalid unit")
+	}
+	if err := validateUnit(UnitDirectory, 1, "atime"); err == nil {
+		t.Errorf("Expected error for invalid unit_age")
+	}
+}
+
+func TestSidecarUnits(t *testing.T) {
+	files := []FileInfo{
+		{Path: "/rec/clip.mp4", Size: 1000, Reclaim: 1000, Age: 100, Ino: 1, Nlink: 1},
+		{Path: "/rec/clip.srt", Size: 10, Reclaim: 10, Age: 300, Ino: 2, Nlink: 1},
+		{Path: "/rec/clip.thumb.jpg", Size: 20, Reclaim: 20, Age: 200, Ino: 3, Nlink: 1},
+		{Path: "/rec/clip.part2.mp4", Size: 500, Reclaim: 500, Age: 150, Ino: 4, Nlink: 1},
+		{Path: "/rec/clip.part2.srt", Size: 5, Reclaim: 5, Age: 150, Ino: 5, Nlink: 1},
+		{Path: "/rec/other.srt", Size: 7, Reclaim: 7, Age: 50, Ino: 6, Nlink: 1},
+		{Path: "/rec/sub/clip.json", Size: 3, Reclaim: 3, Age: 50, Ino: 7, Nlink: 1},
+	}
+
+	m, err := compileSidecarRule(&SidecarRule{Primary: []string{"*.mp4"}})
+	if err != nil {
+		t.Fatalf("compileSidecarRule failed: %v", err)
+	}
+	units := sidecarUnits(files, m)
+
+	byPath := make(map[string]FileInfo)
+	for _, u := range units {
+		byPath[u.Path] = u
+	}
+	if len(units) != 4 {
+		t.Fatalf("Expected 4 candidates, got %v", paths(units))
+	}
+	clip := byPath["/rec/clip.mp4"]
+	if len(clip.Members) != 3 || clip.Members[0].Path != "/rec/clip.mp4" {
+		t.Errorf("Expected clip.mp4 to lead a unit of 3 files, got %+v", clip.Members)
+	}
+	if clip.Size != 1030 || clip.Reclaim != 1030 || clip.Age != 100 {
+		t.Errorf("Unexpected unit totals: size %d, reclaim %d, age %d", clip.Size, clip.Reclaim, clip.Age)
+	}
+	if part2 := byPath["/rec/clip.part2.mp4"]; len(part2.Members) != 2 {
+		t.Errorf("Expected clip.part2.srt to belong to clip.part2.mp4, got %+v", part2.Members)
+	}
+	for _, single := range []string{"/rec/other.srt", "/rec/sub/clip.json"} {
+		if f, ok := byPath[single]; !ok || len(f.Members) != 0 {
+			t.Errorf("Expected %s to remain a single file", single)
+		}
+	}
+
+	// Index files belong to their segments through a key pattern
+	segments := []FileInfo{
+		{Path: "/hls/seg-0001.ts", Size: 100, Age: 10},
+		{Path: "/hls/seg-0001-index.idx", Size: 1, Age: 20},
+	}
+	m, err = compileSidecarRule(&SidecarRule{Primary: []string{"*.ts"}, Key: `^(seg-\d+)`})
+	if err != nil {
+		t.Fatalf("compileSidecarRule failed: %v", err)
+	}
+	if units := sidecarUnits(segments, m); len(units) != 1 || len(units[0].Members) != 2 {
+		t.Errorf("Expected one segment unit, got %+v", units)
+	}
+
+	if _, err := compileSidecarRule(&SidecarRule{}); err == nil {
+		t.Errorf("Expected error for sidecar without primary patterns")
+	}
+}
+
+func TestCleanUp_Sidecars(t *testing.T) {
+	tempDir := t.TempDir()
+	ages := map[string]time.Duration{
+		"old.mp4":       10 * time.Hour,
+		"old.srt":       2 * time.Hour,
+		"old.thumb.jpg": 9 * time.Hour,
+		"new.mp4":       5 * time.Hour,
+		"new.srt":       30 * time.Minute,
+	}
+	for name, age := range ages {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, time.Now(), time.Now().Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// old.mp4 goes with its sidecars, although old.srt is newer than new.mp4.
+	// new.mp4 is next, but its subtitles are younger than min_age.
+	opts := CleanOptions{Sidecar: &SidecarRule{Primary: []string{"*.mp4"}}, MinAge: time.Hour}
+	var paths []string
+	for name := range ages {
+		paths = append(paths, filepath.Join(tempDir, name))
+	}
+	err := CleanUp([]string{tempDir}, allocated(t, paths...), 0, opts)
+	if err == nil {
+		t.Fatalf("Expected error for protected unit")
+	}
+	for _, name := range []string{"old.mp4", "old.srt", "old.thumb.jpg"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+	for _, name := range []string{"new.mp4", "new.srt"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+}
@@ -74,3 +74,11 @@ func formatBytes(bytes uint64) string {
 	}
 	return fmt.Sprintf("%.2f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
+
+// sizeString formats bytes for log output, honoring the human-readable setting
+func sizeString(bytes uint64, humanReadable bool) string {
+	if humanReadable {
+		return formatBytes(bytes)
+	}
+	return fmt.Sprintf("%d bytes", bytes)
+}
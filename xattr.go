@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extended attributes producers can set on their files
+const (
+	xattrPrefix   = "user.vacuum."
+	xattrExpires  = "user.vacuum.expires"  // RFC3339 time or Unix timestamp after which the file is deleted on every check
+	xattrPriority = "user.vacuum.priority" // Integer; higher values are deleted first, negative ones last
+	xattrKeep     = "user.vacuum.keep"     // Never delete the file, whatever its value
+)
+
+// applyXattrs sets the expiry, priority and keep flag of a file from its
+// user.vacuum.* attributes. It reports false if a value doesn't parse; such
+// values are ignored.
+func applyXattrs(f *FileInfo, attrs map[string]string, now time.Time) bool {
+	ok := true
+	if _, keep := attrs[xattrKeep]; keep {
+		f.Keep = true
+	}
+	if value, set := attrs[xattrExpires]; set {
+		expires, err := parseExpires(value)
+		if err != nil {
+			ok = false
+		} else if !now.Before(expires) {
+			f.Expired = true
+		}
+	}
+	if value, set := attrs[xattrPriority]; set {
+		priority, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			ok = false
+		} else {
+			f.Priority = priority
+		}
+	}
+	return ok
+}
+
+// parseExpires parses a user.vacuum.expires value: an RFC3339 time or a Unix
+// timestamp in seconds
+func parseExpires(value string) (time.Time, error) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
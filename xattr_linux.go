@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// vacuumXattrs returns the user.vacuum.* extended attributes of a file. The
+// attribute names are listed first, so files without any cost one syscall.
+func vacuumXattrs(path string) (map[string]string, error) {
+	names, err := xattrCall(func(buf []byte) (int, error) {
+		return syscall.Listxattr(path, buf)
+	})
+	if err != nil {
+		if err == syscall.ENOTSUP || err == syscall.ENODATA {
+			return nil, nil // Filesystem without extended attributes
+		}
+		return nil, err
+	}
+
+	var attrs map[string]string
+	for _, name := range strings.Split(string(names), "\x00") {
+		if !strings.HasPrefix(name, xattrPrefix) {
+			continue
+		}
+		value, err := xattrCall(func(buf []byte) (int, error) {
+			return syscall.Getxattr(path, name, buf)
+		})
+		if err == syscall.ENODATA {
+			continue // Removed in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[name] = string(value)
+	}
+	return attrs, nil
+}
+
+// xattrCall runs a listxattr/getxattr style call, growing the buffer when the
+// result doesn't fit
+func xattrCall(call func(buf []byte) (int, error)) ([]byte, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := call(buf)
+		if err == syscall.ERANGE {
+			if n, err = call(nil); err != nil {
+				return nil, err
+			}
+			buf = make([]byte, n+1)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCleanUp_Xattrs(t *testing.T) {
+	tempDir := t.TempDir()
+	makeTree(t, tempDir, map[string]time.Duration{
+		"expired.dat":  1 * time.Hour,
+		"fresh.dat":    2 * time.Hour,
+		"keep.dat":     3 * time.Hour,
+		"sidecar.mp4":  4 * time.Hour,
+		"sidecar.json": 4 * time.Hour,
+	})
+	setXattr := func(name, attr, value string) {
+		if err := syscall.Setxattr(filepath.Join(tempDir, name), attr, []byte(value), 0); err != nil {
+			if err == syscall.ENOTSUP || err == syscall.EPERM {
+				t.Skipf("Filesystem does not support user xattrs: %v", err)
+			}
+			t.Fatal(err)
+		}
+	}
+	setXattr("expired.dat", xattrExpires, strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	setXattr("fresh.dat", xattrExpires, time.Now().Add(time.Hour).Format(time.RFC3339))
+	setXattr("keep.dat", xattrKeep, "1")
+	setXattr("sidecar.json", xattrKeep, "")
+
+	// Plenty of free space: only the expired file goes
+	opts := CleanOptions{Xattrs: true, Sidecar: &SidecarRule{Primary: []string{"*.mp4"}}}
+	if !opts.runsEveryCheck() {
+		t.Fatalf("Expected xattrs to be checked on every run")
+	}
+	if err := CleanUp([]string{tempDir}, 0, 0, opts); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "expired.dat")); !os.IsNotExist(err) {
+		t.Errorf("expired.dat should have been deleted")
+	}
+
+	// Under pressure everything else goes, except files marked keep and the
+	// sidecar unit holding one
+	err := CleanUp([]string{tempDir}, 1<<30, 0, opts)
+	if err == nil {
+		t.Fatalf("Expected error when kept files block the target")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "fresh.dat")); !os.IsNotExist(err) {
+		t.Errorf("fresh.dat should have been deleted")
+	}
+	for _, name := range []string{"keep.dat", "sidecar.mp4", "sidecar.json"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+			t.Errorf("%s should still exist", name)
+		}
+	}
+}
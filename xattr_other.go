@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errXattrUnsupported = errors.New("extended attributes are only supported on Linux")
+
+// vacuumXattrs is only supported on Linux
+func vacuumXattrs(path string) (map[string]string, error) {
+	return nil, errXattrUnsupported
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyXattrs(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		attrs    map[string]string
+		expired  bool
+		priority int
+		keep     bool
+		ok       bool
+	}{
+		{"none", nil, false, 0, false, true},
+		{"expired rfc3339", map[string]string{xattrExpires: "2024-05-01T11:00:00Z"}, true, 0, false, true},
+		{"not yet expired", map[string]string{xattrExpires: "2024-05-01T13:00:00+00:00"}, false, 0, false, true},
+		{"expired unix", map[string]string{xattrExpires: "1714500000\x00"}, true, 0, false, true},
+		{"priority", map[string]string{xattrPriority: " -5 "}, false, -5, false, true},
+		{"keep", map[string]string{xattrKeep: ""}, false, 0, true, true},
+		{"invalid", map[string]string{xattrExpires: "tomorrow", xattrPriority: "high"}, false, 0, false, false},
+	}
+	for _, tt := range tests {
+		var f FileInfo
+		ok := applyXattrs(&f, tt.attrs, now)
+		if ok != tt.ok || f.Expired != tt.expired || f.Priority != tt.priority || f.Keep != tt.keep {
+			t.Errorf("%s: got expired %v, priority %d, keep %v, ok %v", tt.name, f.Expired, f.Priority, f.Keep, ok)
+		}
+	}
+}
+
+func TestSortCandidates_Priority(t *testing.T) {
+	files := []FileInfo{
+		{Path: "/old", Age: 100},
+		{Path: "/urgent", Age: 300, Priority: 10},
+		{Path: "/last", Age: 50, Priority: -1},
+		{Path: "/mid", Age: 200},
+	}
+	sortCandidates(files, CleanOptions{Xattrs: true}, nil)
+	got := paths(files)
+	expected := []string{"/urgent", "/old", "/mid", "/last"}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("sortCandidates = %v; want %v", got, expected)
+		}
+	}
+}